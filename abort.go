@@ -0,0 +1,147 @@
+package thrash
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const DEFAULT_ABORT_WINDOW = "10s"
+
+// Minimum number of responses in the window before the error rate and
+// latency rules apply.
+const ABORT_MIN_SAMPLES = 20
+
+// How often the latency percentile of the window is recomputed.
+const ABORT_CHECK_INTERVAL = 100 * time.Millisecond
+
+type abortSample struct {
+	time         time.Time
+	failed       bool
+	responseTime time.Duration
+}
+
+// AbortMonitor is an Observer that watches a rolling window of responses and
+// calls Stop, like a circuit breaker opening, as soon as one of its rules is
+// broken: the error rate over ErrorRate percent or the Percentile response
+// time over Latency for at least For, or ConsecutiveFailures failures in a
+// row. A failure is an error or a 5xx response. Zero values disable a rule.
+type AbortMonitor struct {
+	Window              time.Duration
+	ErrorRate           float64
+	Latency             time.Duration
+	Percentile          float64
+	For                 time.Duration
+	ConsecutiveFailures int
+	Stop                func()
+
+	mu           sync.Mutex
+	samples      []abortSample
+	failures     int
+	consecutive  int
+	errorsSince  time.Time
+	latencySince time.Time
+	lastCheck    time.Time
+	reason       string
+}
+
+// NewAbortMonitor returns the monitor for config's abort rules, calling stop
+// when one is broken, or nil if no rule is set.
+func NewAbortMonitor(config Configuration, stop func()) *AbortMonitor {
+	if config.AbortErrorRate <= 0 && config.AbortLatency <= 0 && config.AbortConsecutive <= 0 {
+		return nil
+	}
+	return &AbortMonitor{
+		Window:              config.AbortWindow,
+		ErrorRate:           config.AbortErrorRate,
+		Latency:             config.AbortLatency,
+		Percentile:          config.AbortPercentile,
+		For:                 config.AbortFor,
+		ConsecutiveFailures: config.AbortConsecutive,
+		Stop:                stop,
+	}
+}
+
+// Reason returns why the run was aborted, or "" if it was not.
+func (m *AbortMonitor) Reason() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reason
+}
+
+func (m *AbortMonitor) Observe(r *Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reason != "" {
+		return
+	}
+
+	now := time.Now()
+	sample := abortSample{
+		time:         now,
+		failed:       r.Failed(),
+		responseTime: r.ResponseTime(),
+	}
+	m.samples = append(m.samples, sample)
+	if sample.failed {
+		m.failures++
+		m.consecutive++
+	} else {
+		m.consecutive = 0
+	}
+
+	// Drop the samples that have left the window.
+	expired := 0
+	for expired < len(m.samples) && now.Sub(m.samples[expired].time) > m.Window {
+		if m.samples[expired].failed {
+			m.failures--
+		}
+		expired++
+	}
+	m.samples = m.samples[expired:]
+
+	if m.ConsecutiveFailures > 0 && m.consecutive >= m.ConsecutiveFailures {
+		m.abort(fmt.Sprintf("%d consecutive failures", m.consecutive))
+		return
+	}
+
+	if m.ErrorRate > 0 && len(m.samples) >= ABORT_MIN_SAMPLES {
+		errorRate := float64(m.failures) / float64(len(m.samples)) * 100
+		if m.sustained(errorRate > m.ErrorRate, &m.errorsSince, now) {
+			m.abort(fmt.Sprintf("error rate %.2f%% over %g%% for %v", errorRate, m.ErrorRate, m.For))
+			return
+		}
+	}
+
+	if m.Latency > 0 && len(m.samples) >= ABORT_MIN_SAMPLES && now.Sub(m.lastCheck) >= ABORT_CHECK_INTERVAL {
+		m.lastCheck = now
+		responseTimes := make([]time.Duration, len(m.samples))
+		for i, sample := range m.samples {
+			responseTimes[i] = sample.responseTime
+		}
+		latency := durationPercentiles(responseTimes, []float64{m.Percentile})[0]
+		if m.sustained(latency > m.Latency, &m.latencySince, now) {
+			m.abort(fmt.Sprintf("p%g response time %v over %v for %v", m.Percentile, latency, m.Latency, m.For))
+		}
+	}
+}
+
+// sustained tracks since when broken has held and reports whether it has
+// held for at least For.
+func (m *AbortMonitor) sustained(broken bool, since *time.Time, now time.Time) bool {
+	if !broken {
+		*since = time.Time{}
+		return false
+	}
+	if since.IsZero() {
+		*since = now
+	}
+	return now.Sub(*since) >= m.For
+}
+
+func (m *AbortMonitor) abort(reason string) {
+	m.reason = reason
+	if m.Stop != nil {
+		m.Stop()
+	}
+}
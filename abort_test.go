@@ -0,0 +1,99 @@
+package thrash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func abortResponse(ok bool, statusCode int, responseTime time.Duration) *Response {
+	start := time.Now()
+	response := &Response{OK: ok, StatusCode: statusCode, StartTime: start, EndTime: start.Add(responseTime)}
+	if !ok {
+		response.Error = errors.New("connection refused")
+	}
+	return response
+}
+
+func TestAbortMonitorErrorRate(t *testing.T) {
+	stopped := 0
+	monitor := NewAbortMonitor(Configuration{AbortWindow: time.Minute, AbortErrorRate: 50}, func() { stopped++ })
+
+	monitor.Observe(abortResponse(false, 0, 0))
+	if monitor.Reason() != "" {
+		t.Fatalf("aborted after a single failure: %s", monitor.Reason())
+	}
+
+	for i := 1; i < ABORT_MIN_SAMPLES; i++ {
+		if i%3 == 0 {
+			monitor.Observe(abortResponse(true, 200, 0))
+		} else {
+			monitor.Observe(abortResponse(true, 503, 0))
+		}
+	}
+	if !strings.HasPrefix(monitor.Reason(), "error rate") || stopped != 1 {
+		t.Errorf("got reason %q and %d stops, want an error rate abort", monitor.Reason(), stopped)
+	}
+
+	monitor.Observe(abortResponse(false, 0, 0))
+	if stopped != 1 {
+		t.Errorf("stopped %d times, want once", stopped)
+	}
+}
+
+func TestAbortMonitorErrorRateBelowLimit(t *testing.T) {
+	monitor := NewAbortMonitor(Configuration{AbortWindow: time.Minute, AbortErrorRate: 50}, nil)
+	for i := 0; i < 100; i++ {
+		monitor.Observe(abortResponse(i%4 != 0, 200, 0))
+	}
+	if monitor.Reason() != "" {
+		t.Errorf("aborted at a 25%% error rate: %s", monitor.Reason())
+	}
+}
+
+func TestAbortMonitorFor(t *testing.T) {
+	monitor := NewAbortMonitor(Configuration{AbortWindow: time.Minute, AbortErrorRate: 10, AbortFor: time.Hour}, nil)
+	for i := 0; i < 100; i++ {
+		monitor.Observe(abortResponse(false, 0, 0))
+	}
+	if monitor.Reason() != "" {
+		t.Errorf("aborted before -abort-for passed: %s", monitor.Reason())
+	}
+}
+
+func TestAbortMonitorConsecutive(t *testing.T) {
+	monitor := NewAbortMonitor(Configuration{AbortWindow: time.Minute, AbortConsecutive: 3}, nil)
+	monitor.Observe(abortResponse(false, 0, 0))
+	monitor.Observe(abortResponse(true, 500, 0))
+	monitor.Observe(abortResponse(true, 200, 0))
+	monitor.Observe(abortResponse(false, 0, 0))
+	monitor.Observe(abortResponse(false, 0, 0))
+	if monitor.Reason() != "" {
+		t.Fatalf("aborted after a success broke the run: %s", monitor.Reason())
+	}
+	monitor.Observe(abortResponse(true, 502, 0))
+	if monitor.Reason() != "3 consecutive failures" {
+		t.Errorf("got reason %q, want 3 consecutive failures", monitor.Reason())
+	}
+}
+
+func TestAbortMonitorLatency(t *testing.T) {
+	monitor := NewAbortMonitor(Configuration{AbortWindow: time.Minute, AbortLatency: 100 * time.Millisecond, AbortPercentile: 50}, nil)
+	for i := 0; i < ABORT_MIN_SAMPLES-1; i++ {
+		monitor.Observe(abortResponse(true, 200, time.Second))
+	}
+	if monitor.Reason() != "" {
+		t.Fatalf("aborted before %d samples: %s", ABORT_MIN_SAMPLES, monitor.Reason())
+	}
+	monitor.Observe(abortResponse(true, 200, time.Second))
+	if !strings.HasPrefix(monitor.Reason(), "p50 response time 1s over 100ms") {
+		t.Errorf("got reason %q, want a p50 latency abort", monitor.Reason())
+	}
+}
+
+func TestNewAbortMonitorDisabled(t *testing.T) {
+	if monitor := NewAbortMonitor(Configuration{AbortWindow: time.Minute}, nil); monitor != nil {
+		t.Error("got a monitor with no rules set")
+	}
+}
@@ -0,0 +1,373 @@
+package thrash
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fraction of a token's lifetime after which it is fetched again, so requests
+// never go out with a token about to expire.
+const TOKEN_REFRESH_AFTER = 0.9
+
+// Authorizer adds credentials to each request. Anything it has to fetch to
+// do so, such as a token, is fetched in Authorize before the request is
+// timed, so it is not part of the measured results.
+type Authorizer interface {
+	Authorize(ctx context.Context, req *http.Request) error
+
+	// Unauthorized is called with every 401 response so that the next
+	// Authorize can refresh the credentials.
+	Unauthorized(resp *http.Response)
+}
+
+// NewAuthorizer returns the Authorizer for config, or nil if it only uses
+// basic auth or none. At most one of bearer, oauth2, digest and exec auth may
+// be set.
+func NewAuthorizer(config Configuration) (Authorizer, error) {
+	var authorizers []Authorizer
+	if config.BearerToken != "" {
+		authorizers = append(authorizers, &tokenAuthorizer{token: config.BearerToken})
+	}
+	if config.OAuthTokenUrl != "" {
+		client, err := newTokenClient(config)
+		if err != nil {
+			return nil, err
+		}
+		fetch := func(ctx context.Context) (string, time.Duration, error) {
+			return fetchClientCredentials(ctx, client, config)
+		}
+		authorizers = append(authorizers, &tokenAuthorizer{fetch: fetch})
+	}
+	if config.AuthExec != "" {
+		fetch := func(ctx context.Context) (string, time.Duration, error) {
+			return execToken(ctx, config.AuthExec)
+		}
+		authorizers = append(authorizers, &tokenAuthorizer{fetch: fetch})
+	}
+	if config.Digest {
+		if config.Username == "" || config.Password == "" {
+			return nil, errors.New("digest auth needs a username and password")
+		}
+		client, err := newProbeClient(config)
+		if err != nil {
+			return nil, err
+		}
+		authorizers = append(authorizers, &digestAuthorizer{
+			username: config.Username,
+			password: config.Password,
+			client:   client,
+		})
+	}
+
+	switch len(authorizers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return authorizers[0], nil
+	}
+	return nil, errors.New("only one of bearer, oauth2, digest and exec auth can be used")
+}
+
+// newTokenClient returns the client credentials are fetched with. It shares
+// the run's TLS and proxy settings but not its connections.
+func newTokenClient(config Configuration) (*http.Client, error) {
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := NewProxyFunc(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig, Proxy: proxy}
+	return &http.Client{Transport: transport, Timeout: config.Timeout}, nil
+}
+
+// newProbeClient returns the client digest challenges are probed for with.
+// Unlike a token client it also dials and speaks the protocol the run does,
+// so that the probe reaches the same server over a unix socket or through
+// resolve, connect-to and source overrides.
+func newProbeClient(config Configuration) (*http.Client, error) {
+	client, err := newTokenClient(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := client.Transport.(*http.Transport)
+	transport.Protocols = transportProtocols(config.Protocol)
+	dialer, err := NewDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		transport.DialContext = dialer.DialContext
+	}
+	return client, nil
+}
+
+// tokenAuthorizer sends a bearer token, fetching it with fetch, if set, when
+// it is first needed, once TOKEN_REFRESH_AFTER of its lifetime has passed
+// and after a 401.
+type tokenAuthorizer struct {
+	fetch func(ctx context.Context) (string, time.Duration, error)
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+func (a *tokenAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stale := a.token == "" || (!a.refresh.IsZero() && time.Now().After(a.refresh))
+	if stale && a.fetch != nil {
+		token, lifetime, err := a.fetch(ctx)
+		if err != nil {
+			return fmt.Errorf("fetching token: %v", err)
+		}
+		a.token = token
+		a.refresh = time.Time{}
+		if lifetime > 0 {
+			a.refresh = time.Now().Add(time.Duration(float64(lifetime) * TOKEN_REFRESH_AFTER))
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *tokenAuthorizer) Unauthorized(resp *http.Response) {
+	if a.fetch == nil {
+		return
+	}
+	a.mu.Lock()
+	a.token = ""
+	a.mu.Unlock()
+}
+
+// tokenResponse is an OAuth2 token endpoint response, also accepted from
+// auth exec commands.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// fetchClientCredentials gets a token from config.OAuthTokenUrl with the
+// OAuth2 client credentials grant.
+func fetchClientCredentials(ctx context.Context, client *http.Client, config Configuration) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(config.OAuthScopes) > 0 {
+		form.Set("scope", strings.Join(config.OAuthScopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", config.OAuthTokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(config.OAuthClientID), url.QueryEscape(config.OAuthClientSecret))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	token := tokenResponse{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", 0, err
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("token endpoint returned no access_token")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// execToken runs command with the shell and takes the token from its output,
+// either the token itself or a token response with an expiry.
+func execToken(ctx context.Context, command string) (string, time.Duration, error) {
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).Output()
+	if err != nil {
+		return "", 0, err
+	}
+	output = []byte(strings.TrimSpace(string(output)))
+
+	token := tokenResponse{}
+	if json.Unmarshal(output, &token) == nil && token.AccessToken != "" {
+		return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+	}
+	if len(output) == 0 {
+		return "", 0, errors.New("auth command printed no token")
+	}
+	return string(output), 0, nil
+}
+
+// digestAuthorizer answers the server's HTTP Digest challenge (RFC 7616),
+// probing for one with an unauthenticated request if it has not seen one yet.
+type digestAuthorizer struct {
+	username string
+	password string
+	client   *http.Client
+
+	mu        sync.Mutex
+	challenge map[string]string
+	count     int
+}
+
+func (a *digestAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.challenge == nil {
+		if err := a.probe(ctx, req); err != nil {
+			return fmt.Errorf("fetching digest challenge: %v", err)
+		}
+	}
+
+	var newHash func() hash.Hash
+	algorithm := a.challenge["algorithm"]
+	switch strings.ToUpper(strings.TrimSuffix(algorithm, "-sess")) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		digest := newHash()
+		io.WriteString(digest, s)
+		return hex.EncodeToString(digest.Sum(nil))
+	}
+
+	a.count++
+	nc := fmt.Sprintf("%08x", a.count)
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	realm, nonce := a.challenge["realm"], a.challenge["nonce"]
+	uri := req.URL.RequestURI()
+
+	ha1 := h(a.username + ":" + realm + ":" + a.password)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+
+	qop := ""
+	for _, option := range strings.Split(a.challenge["qop"], ",") {
+		if strings.TrimSpace(option) == "auth" {
+			qop = "auth"
+		}
+	}
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, a.username),
+		fmt.Sprintf(`realm="%s"`, realm),
+		fmt.Sprintf(`nonce="%s"`, nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+	if qop != "" {
+		response := h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce), fmt.Sprintf(`response="%s"`, response))
+	} else {
+		fields = append(fields, fmt.Sprintf(`response="%s"`, h(ha1+":"+nonce+":"+ha2)))
+	}
+	if algorithm != "" {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	if opaque, ok := a.challenge["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	req.Header.Set("Authorization", "Digest "+strings.Join(fields, ", "))
+	return nil
+}
+
+// probe sends req without credentials or a body to get a challenge.
+func (a *digestAuthorizer) probe(ctx context.Context, req *http.Request) error {
+	probe, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(probe)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	a.setChallenge(resp)
+	if a.challenge == nil {
+		return fmt.Errorf("server returned %s without a digest challenge", resp.Status)
+	}
+	return nil
+}
+
+func (a *digestAuthorizer) Unauthorized(resp *http.Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setChallenge(resp)
+}
+
+func (a *digestAuthorizer) setChallenge(resp *http.Response) {
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		if len(header) > 7 && strings.EqualFold(header[:7], "Digest ") {
+			a.challenge = parseAuthParams(header[7:])
+			a.count = 0
+			return
+		}
+	}
+}
+
+// parseAuthParams parses the comma separated name=value pairs of a
+// challenge, where values may be quoted and contain commas.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+
+		value := ""
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value = s[1 : end+1]
+			s = s[min(end+2, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[name] = value
+	}
+	return params
+}
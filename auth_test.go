@@ -0,0 +1,172 @@
+package thrash
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		s    string
+		want map[string]string
+	}{
+		{`realm="test", nonce="abc123", qop="auth,auth-int", algorithm=MD5`,
+			map[string]string{"realm": "test", "nonce": "abc123", "qop": "auth,auth-int", "algorithm": "MD5"}},
+		{`Realm="a, b",opaque=xyz , stale=false`,
+			map[string]string{"realm": "a, b", "opaque": "xyz", "stale": "false"}},
+		{`realm=""`, map[string]string{"realm": ""}},
+		{`realm="unterminated`, map[string]string{"realm": "unterminated"}},
+		{`no-equals`, map[string]string{}},
+		{``, map[string]string{}},
+	}
+	for _, test := range tests {
+		if got := parseAuthParams(test.s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseAuthParams(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+// digestServer requires HTTP Digest auth with qop=auth for user:secret.
+func digestServer(probes *int64) http.HandlerFunc {
+	md5Hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			atomic.AddInt64(probes, 1)
+			rw.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="n0nce", qop="auth", opaque="op"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		params := parseAuthParams(auth[7:])
+		ha1 := md5Hex("user:test:secret")
+		ha2 := md5Hex(r.Method + ":" + params["uri"])
+		want := md5Hex(strings.Join([]string{ha1, "n0nce", params["nc"], params["cnonce"], "auth", ha2}, ":"))
+		if params["response"] != want || params["opaque"] != "op" || params["uri"] != r.URL.RequestURI() {
+			rw.WriteHeader(http.StatusForbidden)
+		}
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	var probes int64
+	server := httptest.NewServer(digestServer(&probes))
+	defer server.Close()
+
+	executor, err := NewHTTPExecutor(Configuration{Url: server.URL + "/private?x=1", Username: "user", Password: "secret", Digest: true, Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if response := executor.Execute(context.Background(), i); response.StatusCode != http.StatusOK {
+			t.Errorf("request %d: got %d %v", i, response.StatusCode, response.Error)
+		}
+	}
+	if probes != 1 {
+		t.Errorf("probed %d times, want once", probes)
+	}
+}
+
+func TestDigestAuthUnixSocket(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "thrash.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var probes int64
+	server := httptest.NewUnstartedServer(digestServer(&probes))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	executor, err := NewHTTPExecutor(Configuration{Url: "http://api.invalid/", UnixSocket: listener.Addr().String(), Username: "user", Password: "secret", Digest: true, Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := executor.Execute(context.Background(), 0); response.StatusCode != http.StatusOK {
+		t.Errorf("got %d %v", response.StatusCode, response.Error)
+	}
+	if probes != 1 {
+		t.Errorf("probed the socket %d times, want once", probes)
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	var fetches int64
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			http.Error(rw, "bad client", http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt64(&fetches, 1)
+		fmt.Fprintf(rw, `{"access_token":"token%d","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	var revoked int64
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Revoke the first token after its first use.
+		if r.Header.Get("Authorization") == "Bearer token1" && atomic.AddInt64(&revoked, 1) > 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token") {
+			rw.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	executor, err := NewHTTPExecutor(Configuration{
+		Url:               server.URL,
+		OAuthTokenUrl:     tokenServer.URL,
+		OAuthClientID:     "client",
+		OAuthClientSecret: "s3cret",
+		OAuthScopes:       []string{"read", "write"},
+		Concurrency:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		codes = append(codes, executor.Execute(context.Background(), i).StatusCode)
+	}
+	if !reflect.DeepEqual(codes, []int{200, 401, 200}) || fetches != 2 {
+		t.Errorf("got status codes %v after %d token fetches, want [200 401 200] after 2", codes, fetches)
+	}
+}
+
+func TestExecToken(t *testing.T) {
+	token, lifetime, err := execToken(context.Background(), `echo '{"access_token":"abc","expires_in":60}'`)
+	if err != nil || token != "abc" || lifetime.Seconds() != 60 {
+		t.Errorf("got %q, %v, %v, want abc for 60s", token, lifetime, err)
+	}
+	token, lifetime, err = execToken(context.Background(), "echo plain-token")
+	if err != nil || token != "plain-token" || lifetime != 0 {
+		t.Errorf("got %q, %v, %v, want plain-token", token, lifetime, err)
+	}
+	if _, _, err := execToken(context.Background(), "true"); err == nil {
+		t.Error("empty output succeeded")
+	}
+}
+
+func TestNewAuthorizerConflicts(t *testing.T) {
+	if _, err := NewAuthorizer(Configuration{BearerToken: "abc", AuthExec: "echo token"}); err == nil {
+		t.Error("bearer and exec auth together succeeded")
+	}
+	if _, err := NewAuthorizer(Configuration{Digest: true, Username: "user"}); err == nil {
+		t.Error("digest auth without a password succeeded")
+	}
+}
@@ -1,3 +1,3 @@
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o thrash .
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o thrash ./cmd/thrash
 docker build -t tbrock/thrash:latest .
 
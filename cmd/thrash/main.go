@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TylerBrock/thrash"
+	"github.com/cheggaaa/pb"
+	"golang.org/x/text/message"
+)
+
+func startProfiler() {
+	go func() {
+		log.Println(http.ListenAndServe("localhost:6060", nil))
+	}()
+}
+
+func configure() *thrash.Configuration {
+	config := thrash.Configuration{}
+	defaultTimeoutDuration, _ := time.ParseDuration(thrash.DEFAULT_TIMEOUT)
+	flag.IntVar(&config.Concurrency, "c", thrash.DEFAULT_CONCURRENCY, "how much concurrency")
+	flag.IntVar(&config.NumRequests, "n", thrash.DEFAULT_NUM_REQUESTS, "how many requests")
+	flag.DurationVar(&config.Timeout, "t", defaultTimeoutDuration, "total request timeout")
+	flag.BoolVar(&config.Histogram, "d", false, "print response time histogram")
+	flag.BoolVar(&config.PrintErrors, "e", false, "print errors")
+	//flag.BoolVar(&config.Profile, "p", false, "start the profile server on port 6060")
+	flag.StringVar(&config.Username, "u", "", "username for basic auth")
+	flag.StringVar(&config.Password, "p", "", "password for basic auth")
+	headerStr := flag.String("h", "", "request headers key:value")
+	flag.BoolVar(&config.Digest, "digest", false, "use -u and -p for http digest auth instead of basic auth")
+	flag.StringVar(&config.BearerToken, "bearer", "", "send this bearer token")
+	flag.StringVar(&config.OAuthTokenUrl, "oauth-token-url", "", "get bearer tokens from this oauth2 token endpoint with the client credentials grant")
+	flag.StringVar(&config.OAuthClientID, "oauth-client-id", "", "oauth2 client id")
+	flag.StringVar(&config.OAuthClientSecret, "oauth-client-secret", "", "oauth2 client secret")
+	oauthScopesStr := flag.String("oauth-scopes", "", "comma separated oauth2 scopes to request")
+	flag.StringVar(&config.Sign, "sign", "", "sign each request: sigv4 or hmac")
+	flag.StringVar(&config.AWSRegion, "aws-region", "", "sigv4 region (default $AWS_REGION or $AWS_DEFAULT_REGION)")
+	flag.StringVar(&config.AWSService, "aws-service", "", "sigv4 service name, e.g. s3 or execute-api")
+	flag.StringVar(&config.AWSAccessKey, "aws-access-key", "", "sigv4 access key id (default $AWS_ACCESS_KEY_ID)")
+	flag.StringVar(&config.AWSSecretKey, "aws-secret-key", "", "sigv4 secret access key (default $AWS_SECRET_ACCESS_KEY)")
+	flag.StringVar(&config.AWSSessionToken, "aws-session-token", "", "sigv4 session token for temporary credentials (default $AWS_SESSION_TOKEN)")
+	flag.StringVar(&config.HMACKey, "hmac-key", "", "hmac signing secret")
+	flag.StringVar(&config.HMACKeyID, "hmac-key-id", "", "key id available to -hmac-format as {{key_id}}")
+	flag.StringVar(&config.HMACHeader, "hmac-header", thrash.DEFAULT_HMAC_HEADER, "header to send the hmac signature in")
+	flag.StringVar(&config.HMACAlgorithm, "hmac-algorithm", thrash.DEFAULT_HMAC_ALGORITHM, "hmac hash: sha1, sha256 or sha512")
+	flag.StringVar(&config.HMACEncoding, "hmac-encoding", thrash.DEFAULT_HMAC_ENCODING, "hmac signature encoding: base64 or hex")
+	flag.StringVar(&config.HMACStringToSign, "hmac-string", thrash.DEFAULT_HMAC_STRING, "template of the string to sign, with go escapes")
+	flag.StringVar(&config.HMACFormat, "hmac-format", thrash.DEFAULT_HMAC_FORMAT, "template of the hmac header value")
+	flag.StringVar(&config.AuthExec, "auth-exec", "", "get bearer tokens from the output of this shell command (a token or a json token response)")
+	flag.StringVar(&config.LogRequests, "log-requests", "", "write every response to this file")
+	flag.StringVar(&config.LogFormat, "log-format", "jsonl", "format of the request log (jsonl or csv)")
+	flag.StringVar(&config.MetricsAddr, "metrics", "", "serve prometheus metrics on this address (e.g. :9090)")
+	defaultSinkInterval, _ := time.ParseDuration(thrash.DEFAULT_SINK_INTERVAL)
+	flag.StringVar(&config.InfluxUrl, "influx", "", "push interval metrics to this influxdb write url")
+	flag.StringVar(&config.StatsDAddr, "statsd", "", "push interval metrics to this statsd host:port")
+	flag.DurationVar(&config.SinkInterval, "sink-interval", defaultSinkInterval, "how often to push interval metrics")
+	flag.StringVar(&config.ReportPath, "report", "", "write an html report to this file")
+	flag.StringVar(&config.JSONPath, "json", "", "save the run as json for thrash compare")
+	workersStr := flag.String("workers", "", "comma separated worker host:port list to distribute the run across")
+	flag.StringVar(&config.WorkerToken, "worker-token", os.Getenv(thrash.WORKER_TOKEN_ENV), "token the workers were started with (default $"+thrash.WORKER_TOKEN_ENV+")")
+	payloadStr := flag.String("payload", escape(thrash.DEFAULT_PAYLOAD), "data to send to tcp:// and udp:// targets, with go escapes")
+	delimStr := flag.String("delim", escape(thrash.DEFAULT_DELIMITER), "byte that ends a tcp:// reply, with go escapes")
+	wsMessagesPath := flag.String("ws-messages", "", "file of messages, one per line, for ws:// targets to send in turn")
+	flag.DurationVar(&config.WSInterval, "ws-interval", 0, "minimum time between messages from each ws:// virtual user")
+	flag.IntVar(&config.Users, "users", 0, "run this many virtual users, each with its own connections and cookies, instead of -c (defaults to -c with -scenario or -think)")
+	scenarioPath := flag.String("scenario", "", "json file of steps for each virtual user to loop over")
+	flag.StringVar(&config.ThinkTime, "think", "", "virtual user think time: constant:1s, uniform:500ms-2s, exponential:1s or normal:1s,200ms")
+	flag.StringVar(&config.Cookies, "cookies", "", "cookie jar: shared, user (one per virtual user) or off (default user with -users, shared with -cookie-file, otherwise off)")
+	flag.StringVar(&config.CookieFile, "cookie-file", "", "netscape format cookie file to seed the cookie jars with")
+	flag.BoolVar(&config.Stream, "stream", false, "hold responses open as streams and time their events (sse or chunks)")
+	flag.DurationVar(&config.StreamDuration, "stream-duration", 0, "close each stream after this long (0 waits for the server)")
+	flag.IntVar(&config.StreamEvents, "stream-events", 0, "close each stream after this many events (0 for no limit)")
+	flag.StringVar(&config.Protocol, "proto", "auto", "http protocol: "+strings.Join(thrash.SupportedProtocols, ", "))
+	flag.IntVar(&config.MaxConns, "max-conns", 0, "max tcp connections per host, shared by http/2 streams (0 for no limit)")
+	flag.BoolVar(&config.StrictStreams, "strict-streams", false, "queue http/2 requests at the server's stream limit instead of opening more connections")
+	flag.DurationVar(&config.DialTimeout, "dial-timeout", 0, "timeout for establishing connections (0 for none beyond -t)")
+	flag.DurationVar(&config.TLSTimeout, "tls-timeout", 0, "timeout for tls handshakes (0 for none beyond -t)")
+	flag.DurationVar(&config.HeaderTimeout, "header-timeout", 0, "timeout waiting for response headers once the request is sent (0 for none beyond -t)")
+	flag.DurationVar(&config.BodyTimeout, "body-timeout", 0, "timeout reading the response body once the headers are in (0 for none beyond -t)")
+	flag.DurationVar(&config.IdleTimeout, "idle-timeout", 0, "close pooled connections idle for this long (0 for no limit)")
+	defaultRetryBackoff, _ := time.ParseDuration(thrash.DEFAULT_RETRY_BACKOFF)
+	defaultRetryMaxBackoff, _ := time.ParseDuration(thrash.DEFAULT_RETRY_MAX_BACKOFF)
+	flag.IntVar(&config.Retries, "retries", 0, "retry each request up to this many times")
+	retryOnStr := flag.String("retry-on", thrash.DEFAULT_RETRY_ON, "comma separated status codes and error classes to retry")
+	flag.DurationVar(&config.RetryBackoff, "retry-backoff", defaultRetryBackoff, "delay before the first retry, doubled for each one after")
+	flag.DurationVar(&config.RetryMaxBackoff, "retry-max-backoff", defaultRetryMaxBackoff, "longest delay between retries")
+	flag.Float64Var(&config.Rate, "rate", 0, "requests per second to offer, at most -c in flight (0 for as fast as possible)")
+	flag.StringVar(&config.Search, "search", "", "search for the highest concurrency or rate that meets the slo (concurrency or rate)")
+	flag.Float64Var(&config.SearchStart, "search-start", 1, "first level of the search")
+	flag.Float64Var(&config.SearchMax, "search-max", 1000, "highest level of the search")
+	flag.Float64Var(&config.SearchStep, "search-step", 0, "grow the level by this much each time (0 doubles it and then bisects)")
+	flag.Float64Var(&config.SLOPercentile, "slo-percentile", 99, "response time percentile the slo applies to")
+	flag.DurationVar(&config.SLOLatency, "slo-latency", 0, "highest passing response time at -slo-percentile (0 for no limit)")
+	flag.Float64Var(&config.SLOErrorRate, "slo-errors", 1, "highest passing percentage of errors and 5xx responses")
+	defaultAbortWindow, _ := time.ParseDuration(thrash.DEFAULT_ABORT_WINDOW)
+	flag.DurationVar(&config.AbortWindow, "abort-window", defaultAbortWindow, "rolling window the abort rules are evaluated over")
+	flag.Float64Var(&config.AbortErrorRate, "abort-errors", 0, "abort when the percentage of errors and 5xx responses in the window is above this (0 to disable)")
+	flag.DurationVar(&config.AbortLatency, "abort-latency", 0, "abort when the -abort-percentile response time in the window is above this (0 to disable)")
+	flag.Float64Var(&config.AbortPercentile, "abort-percentile", 99, "response time percentile -abort-latency applies to")
+	flag.DurationVar(&config.AbortFor, "abort-for", 0, "how long -abort-errors or -abort-latency must be exceeded before aborting")
+	flag.IntVar(&config.AbortConsecutive, "abort-consecutive", 0, "abort after this many failures in a row (0 to disable)")
+	flag.BoolVar(&config.DisableKeepAlives, "no-keepalive", false, "open a new connection for every request")
+	flag.IntVar(&config.ConnMaxRequests, "conn-max-requests", 0, "close each connection after this many requests (0 for no limit)")
+	flag.DurationVar(&config.ConnMaxAge, "conn-max-age", 0, "close each connection once it has been open this long (0 for no limit)")
+	flag.StringVar(&config.Proxy, "proxy", "", "send requests through this proxy (http://, https:// or socks5://, with user:pass@ for auth)")
+	sourceStr := flag.String("source", "", "comma separated local addresses or interfaces to spread connections across")
+	flag.StringVar(&config.UnixSocket, "unix", "", "connect to this unix socket instead of the url's host")
+	resolveStr := flag.String("resolve", "", "space separated host:port:addr[,addr...] overrides for dns")
+	connectToStr := flag.String("connect-to", "", "space separated host:port:connect-host:connect-port connection redirects")
+	flag.BoolVar(&config.RoundRobin, "round-robin", false, "spread connections across every address a host resolves to")
+	flag.StringVar(&config.TLSCert, "cert", "", "client certificate file (pem) for mtls")
+	flag.StringVar(&config.TLSKey, "key", "", "client private key file (pem) for mtls")
+	flag.StringVar(&config.TLSCA, "cacert", "", "ca bundle file (pem) to verify the server with")
+	flag.StringVar(&config.TLSServerName, "servername", "", "override the tls server name (sni and verification)")
+	flag.StringVar(&config.TLSMinVersion, "tls-min", "", "minimum tls version (1.0, 1.1, 1.2 or 1.3)")
+	flag.StringVar(&config.TLSMaxVersion, "tls-max", "", "maximum tls version (1.0, 1.1, 1.2 or 1.3)")
+	ciphersStr := flag.String("ciphers", "", "comma separated tls 1.0-1.2 cipher suite names")
+	flag.BoolVar(&config.TLSInsecure, "insecure", false, "skip tls certificate verification")
+	tagStr := flag.String("tags", "", "metric tags key:value (run_id and target are set by default)")
+	flag.Parse()
+
+	flag.Usage = func() {
+		printUsage()
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-help" {
+		printUsage()
+		os.Exit(1)
+	}
+
+	urlArg := os.Args[len(os.Args)-1]
+
+	_, err := url.ParseRequestURI(urlArg)
+	if err != nil {
+		fmt.Printf("Error: \"%s\" does not look like a valid url!\n", urlArg)
+		printUsage()
+		os.Exit(2)
+	} else {
+		config.Url = urlArg
+	}
+
+	validProtocol := false
+	for _, protocol := range thrash.SupportedProtocols {
+		if config.Protocol == protocol {
+			validProtocol = true
+		}
+	}
+	if !validProtocol {
+		fmt.Printf("Error: unknown protocol \"%s\"\n", config.Protocol)
+		return nil
+	}
+
+	if *headerStr != "" {
+		config.Headers = make(map[string]string)
+		headerSlice := strings.Fields(*headerStr)
+		for _, header := range headerSlice {
+			parts := strings.Split(header, ":")
+			if len(parts) != 2 {
+				return nil
+			}
+			key := parts[0]
+			value := parts[1]
+			config.Headers[key] = value
+		}
+	}
+
+	target, _ := url.Parse(config.Url)
+	config.Tags = map[string]string{
+		"run_id": fmt.Sprintf("%d", time.Now().Unix()),
+		"target": target.Host,
+	}
+	for _, tag := range strings.Fields(*tagStr) {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) != 2 {
+			return nil
+		}
+		config.Tags[parts[0]] = parts[1]
+	}
+
+	config.Payload = unescape(*payloadStr)
+	config.Delimiter = unescape(*delimStr)
+
+	if *wsMessagesPath != "" {
+		data, err := ioutil.ReadFile(*wsMessagesPath)
+		if err != nil {
+			fmt.Println("Error reading messages:", err)
+			return nil
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				config.WSMessages = append(config.WSMessages, line)
+			}
+		}
+	}
+
+	if *scenarioPath != "" {
+		scenario, err := thrash.LoadScenario(*scenarioPath)
+		if err != nil {
+			fmt.Println("Error reading scenario:", err)
+			return nil
+		}
+		config.Scenario = scenario
+	}
+	if config.Users == 0 && (config.Scenario != nil || config.ThinkTime != "") {
+		config.Users = config.Concurrency
+	}
+
+	if *oauthScopesStr != "" {
+		config.OAuthScopes = strings.Split(*oauthScopesStr, ",")
+	}
+
+	config.RetryOn = strings.Split(*retryOnStr, ",")
+
+	if *sourceStr != "" {
+		config.SourceAddrs = strings.Split(*sourceStr, ",")
+	}
+	config.Resolve = strings.Fields(*resolveStr)
+	config.ConnectTo = strings.Fields(*connectToStr)
+
+	if *ciphersStr != "" {
+		config.TLSCiphers = strings.Split(*ciphersStr, ",")
+	}
+
+	if *workersStr != "" {
+		config.Workers = strings.Split(*workersStr, ",")
+		if flags := observerFlags(config); len(flags) > 0 {
+			fmt.Printf("Error: %s can't be used with -workers\n", strings.Join(flags, ", "))
+			return nil
+		}
+	}
+
+	if config.Search != "" {
+		flags := observerFlags(config)
+		if config.ReportPath != "" {
+			flags = append(flags, "-report")
+		}
+		if config.JSONPath != "" {
+			flags = append(flags, "-json")
+		}
+		if len(flags) > 0 {
+			fmt.Printf("Error: %s can't be used with -search\n", strings.Join(flags, ", "))
+			return nil
+		}
+	}
+
+	return &config
+}
+
+// observerFlags lists the flags set in config that need to see every
+// response as it arrives.
+func observerFlags(config thrash.Configuration) []string {
+	var flags []string
+	if config.MetricsAddr != "" {
+		flags = append(flags, "-metrics")
+	}
+	if config.LogRequests != "" {
+		flags = append(flags, "-log-requests")
+	}
+	if config.InfluxUrl != "" {
+		flags = append(flags, "-influx")
+	}
+	if config.StatsDAddr != "" {
+		flags = append(flags, "-statsd")
+	}
+	if config.PrintErrors {
+		flags = append(flags, "-e")
+	}
+	if config.AbortErrorRate > 0 || config.AbortLatency > 0 || config.AbortConsecutive > 0 {
+		flags = append(flags, "abort rules")
+	}
+	return flags
+}
+
+// escape renders s with go escape sequences, without surrounding quotes.
+func escape(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
+
+// unescape interprets go escape sequences in s, returning s as is if it is
+// not a valid escaped string.
+func unescape(s string) string {
+	unquoted, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return s
+	}
+	return unquoted
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] url\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s compare [flags] old.json new.json\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s worker [-listen addr] [-token token]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		os.Exit(runWorker(os.Args[2:]))
+	}
+
+	configPtr := configure()
+
+	if configPtr == nil {
+		fmt.Println("Error in configuration! Exiting!")
+		os.Exit(1)
+	}
+
+	config := *configPtr
+
+	fmt.Println("Thrashing", config.Url)
+
+	p := message.NewPrinter(message.MatchLanguage("en"))
+	if config.Users > 0 {
+		p.Println("Users", config.Users, "Num Requests", config.NumRequests)
+	} else {
+		p.Println("Concurrency", config.Concurrency, "Num Requests", config.NumRequests)
+	}
+
+	if config.Profile {
+		startProfiler()
+	}
+
+	if config.Search != "" {
+		os.Exit(runSearch(config))
+	}
+
+	runner, err := thrash.NewRunner(config)
+	if err != nil {
+		fmt.Println("Error in configuration:", err)
+		os.Exit(1)
+	}
+
+	if config.MetricsAddr != "" {
+		metrics := thrash.NewMetrics(config)
+		thrash.StartMetricsServer(config.MetricsAddr, metrics)
+		runner.Observers = append(runner.Observers, metrics)
+	}
+
+	var sinks []thrash.Sink
+	if config.InfluxUrl != "" {
+		sink, err := thrash.NewInfluxSink(config.InfluxUrl, config.Tags)
+		if err != nil {
+			fmt.Println("Error configuring influxdb sink:", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+	if config.StatsDAddr != "" {
+		sink, err := thrash.NewStatsDSink(config.StatsDAddr, config.Tags)
+		if err != nil {
+			fmt.Println("Error configuring statsd sink:", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	var aggregator *thrash.IntervalAggregator
+	if len(sinks) > 0 {
+		aggregator = thrash.NewIntervalAggregator(sinks)
+		aggregator.Start(config.SinkInterval)
+		runner.Observers = append(runner.Observers, aggregator)
+	}
+
+	var requestLogger *thrash.RequestLogger
+	if config.LogRequests != "" {
+		var err error
+		requestLogger, err = thrash.NewRequestLogger(config.LogRequests, config.LogFormat)
+		if err != nil {
+			fmt.Println("Error opening request log:", err)
+			os.Exit(1)
+		}
+		runner.Observers = append(runner.Observers, requestLogger)
+	}
+
+	if config.PrintErrors {
+		runner.Observers = append(runner.Observers, thrash.ObserverFunc(func(r *thrash.Response) {
+			if r.OK != true {
+				fmt.Println(r.Error)
+			}
+		}))
+	}
+
+	abortMonitor := thrash.NewAbortMonitor(config, runner.Stop)
+	if abortMonitor != nil {
+		runner.Observers = append(runner.Observers, abortMonitor)
+	}
+
+	bar := pb.StartNew(config.NumRequests)
+	runner.Progress = func(n int) { bar.Add(n) }
+
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		fmt.Println("Error running:", err)
+		os.Exit(1)
+	}
+
+	bar.Finish()
+
+	if abortMonitor != nil {
+		summary.AbortReason = abortMonitor.Reason()
+	}
+
+	if aggregator != nil {
+		aggregator.Close()
+	}
+
+	if requestLogger != nil {
+		if err := requestLogger.Close(); err != nil {
+			fmt.Println("Error writing request log:", err)
+		}
+	}
+
+	reporters := []thrash.Reporter{&thrash.TextReporter{Histogram: config.Histogram}}
+	if config.ReportPath != "" {
+		reporters = append(reporters, &thrash.HTMLReporter{Path: config.ReportPath})
+	}
+	if config.JSONPath != "" {
+		reporters = append(reporters, &thrash.JSONReporter{Path: config.JSONPath})
+	}
+	for _, reporter := range reporters {
+		if err := reporter.Report(config, summary); err != nil {
+			fmt.Println("Error writing report:", err)
+		}
+	}
+
+	if summary.AbortReason != "" {
+		os.Exit(1)
+	}
+}
+
+func runCompare(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	tolerances := thrash.CompareTolerances{}
+	flags.Float64Var(&tolerances.LatencyPct, "latency-tolerance", 10, "allowed percentile increase in percent")
+	flags.Float64Var(&tolerances.ThroughputPct, "throughput-tolerance", 10, "allowed throughput decrease in percent")
+	flags.Float64Var(&tolerances.ErrorRatePts, "error-tolerance", 1, "allowed error rate increase in percentage points")
+	flags.Float64Var(&tolerances.Alpha, "alpha", 0.05, "significance level for the rank test")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s compare [flags] old.json new.json\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	oldRun, err := thrash.LoadSavedRun(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error loading run:", err)
+		return 2
+	}
+	newRun, err := thrash.LoadSavedRun(flags.Arg(1))
+	if err != nil {
+		fmt.Println("Error loading run:", err)
+		return 2
+	}
+
+	fmt.Println("Old:", flags.Arg(0), oldRun.Url)
+	fmt.Println("New:", flags.Arg(1), newRun.Url)
+	fmt.Println()
+
+	comparison := thrash.CompareRuns(oldRun, newRun, tolerances)
+	printComparison(comparison, tolerances)
+	if len(comparison.Regressions) == 0 {
+		return 0
+	}
+
+	fmt.Println()
+	for _, regression := range comparison.Regressions {
+		fmt.Println("REGRESSION:", regression)
+	}
+	return 1
+}
+
+func printComparison(c *thrash.Comparison, tolerances thrash.CompareTolerances) {
+	fmt.Printf("%-22s %14s %14s %10s\n", "", "old", "new", "delta")
+	fmt.Printf("%-22s %14.2f %14.2f %+9.1f%%\n", c.Throughput.Name, c.Throughput.Old, c.Throughput.New, c.Throughput.Delta)
+	fmt.Printf("%-22s %13.2f%% %13.2f%% %+8.2fpt\n", c.ErrorRate.Name, c.ErrorRate.Old, c.ErrorRate.New, c.ErrorRate.Delta)
+	for _, latency := range c.Latencies {
+		fmt.Printf("%-22s %12.3fms %12.3fms %+9.1f%%\n", latency.Name, latency.Old, latency.New, latency.Delta)
+	}
+
+	fmt.Printf("\nMann-Whitney U: z=%.3f p=%.4g (%d vs %d samples)\n", c.Z, c.P, c.OldSamples, c.NewSamples)
+	switch {
+	case c.P >= tolerances.Alpha:
+		fmt.Println("No significant difference in response times")
+	case c.Z > 0:
+		fmt.Printf("New run is significantly SLOWER (alpha %g)\n", tolerances.Alpha)
+	default:
+		fmt.Printf("New run is significantly faster (alpha %g)\n", tolerances.Alpha)
+	}
+}
+
+func runWorker(args []string) int {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	listen := flags.String("listen", thrash.DEFAULT_WORKER_ADDR, "address to accept controller connections on")
+	token := flags.String("token", os.Getenv(thrash.WORKER_TOKEN_ENV), "token controllers have to send (default $"+thrash.WORKER_TOKEN_ENV+")")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s worker [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	mux := http.NewServeMux()
+	mux.Handle("/run", &thrash.Worker{Token: *token})
+
+	log.Println("Worker listening on", *listen)
+	if *token == "" {
+		log.Println("Warning: no -token set, any client that can reach the worker can run load from it")
+	}
+	log.Println(http.ListenAndServe(*listen, mux))
+	return 1
+}
+
+func runSearch(config thrash.Configuration) int {
+	search := thrash.NewCapacitySearch(config)
+	fmt.Printf("Searching %s for p%g <= %v and errors <= %g%%\n",
+		config.Search, search.SLO.Percentile, search.SLO.MaxLatency, search.SLO.MaxErrorRate)
+	fmt.Printf("%12s %12s %10s %12s %12s  %s\n", config.Search, "requests/sec", "errors", "p50", fmt.Sprintf("p%g", search.SLO.Percentile), "result")
+
+	search.OnLevel = func(level *thrash.SearchLevel) {
+		result := "pass"
+		if !level.Pass {
+			result = "FAIL " + strings.Join(level.Violations, ", ")
+		}
+		fmt.Printf("%12g %12.2f %9.2f%% %10.3fms %10.3fms  %s\n", level.Level, level.Run.RequestsPerSecond,
+			level.ErrorRate, level.Run.Percentiles["p50"], float64(level.Latency)/float64(time.Millisecond), result)
+	}
+
+	_, best, err := search.Run(context.Background())
+	if err != nil {
+		fmt.Println("Error running search:", err)
+		return 1
+	}
+
+	fmt.Println()
+	if best == nil {
+		fmt.Println("No level met the SLO")
+		return 1
+	}
+	fmt.Printf("Highest passing %s: %g (%.2f requests/sec)\n", config.Search, best.Level, best.Run.RequestsPerSecond)
+	return 0
+}
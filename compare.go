@@ -0,0 +1,143 @@
+package thrash
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CompareTolerances are the regressions compare allows before it exits
+// non-zero. Percentages are relative to the old run, except the error rate
+// which is in absolute percentage points.
+type CompareTolerances struct {
+	LatencyPct    float64
+	ThroughputPct float64
+	ErrorRatePts  float64
+	Alpha         float64
+}
+
+// mannWhitneyU runs a two-sided Mann-Whitney U test on two samples using the
+// normal approximation with a tie correction, returning the z score and p
+// value. A positive z means values in b tend to be larger than in a.
+func mannWhitneyU(a []float64, b []float64) (float64, float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+
+	type rankedValue struct {
+		value float64
+		fromB bool
+	}
+	values := make([]rankedValue, 0, len(a)+len(b))
+	for _, v := range a {
+		values = append(values, rankedValue{v, false})
+	}
+	for _, v := range b {
+		values = append(values, rankedValue{v, true})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].value < values[j].value })
+
+	// Assign average ranks to ties and accumulate the tie correction term.
+	rankSumB := 0.0
+	tieCorrection := 0.0
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].value == values[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if values[k].fromB {
+				rankSumB += rank
+			}
+		}
+		ties := float64(j - i)
+		tieCorrection += ties*ties*ties - ties
+		i = j
+	}
+
+	n := n1 + n2
+	u := rankSumB - n2*(n2+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 0, 1
+	}
+	z := (u - mean) / math.Sqrt(variance)
+	p := math.Erfc(math.Abs(z) / math.Sqrt2)
+	return z, p
+}
+
+func pctChange(before float64, after float64) float64 {
+	if before == 0 {
+		if after == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (after - before) / before * 100
+}
+
+// CompareDelta is one measure of an old and a new run and the change
+// between them.
+type CompareDelta struct {
+	Name  string
+	Old   float64
+	New   float64
+	Delta float64
+}
+
+// Comparison is the outcome of comparing two saved runs.
+type Comparison struct {
+	Throughput CompareDelta   // requests/sec, Delta in percent
+	ErrorRate  CompareDelta   // percent, Delta in percentage points
+	Latencies  []CompareDelta // avg and percentiles in ms, Delta in percent
+
+	// Mann-Whitney U test on the sampled response times. A positive Z means
+	// the new run is slower.
+	Z          float64
+	P          float64
+	OldSamples int
+	NewSamples int
+
+	// Regressions lists the tolerances that were exceeded.
+	Regressions []string
+}
+
+// CompareRuns compares two saved runs against tolerances.
+func CompareRuns(oldRun *SavedRun, newRun *SavedRun, tolerances CompareTolerances) *Comparison {
+	c := &Comparison{}
+
+	c.Throughput = CompareDelta{"Requests/sec", oldRun.RequestsPerSecond, newRun.RequestsPerSecond,
+		pctChange(oldRun.RequestsPerSecond, newRun.RequestsPerSecond)}
+	if -c.Throughput.Delta > tolerances.ThroughputPct {
+		c.Regressions = append(c.Regressions, fmt.Sprintf("throughput dropped %.1f%% (tolerance %g%%)", -c.Throughput.Delta, tolerances.ThroughputPct))
+	}
+
+	oldErrorPct, newErrorPct := oldRun.ErrorRate*100, newRun.ErrorRate*100
+	c.ErrorRate = CompareDelta{"Error rate", oldErrorPct, newErrorPct, newErrorPct - oldErrorPct}
+	if c.ErrorRate.Delta > tolerances.ErrorRatePts {
+		c.Regressions = append(c.Regressions, fmt.Sprintf("error rate rose %.2f points (tolerance %g)", c.ErrorRate.Delta, tolerances.ErrorRatePts))
+	}
+
+	c.Latencies = append(c.Latencies, CompareDelta{"Avg", oldRun.AvgMs, newRun.AvgMs, pctChange(oldRun.AvgMs, newRun.AvgMs)})
+	for _, p := range summaryPercentiles {
+		key := percentileKey(p)
+		oldValue, oldOK := oldRun.Percentiles[key]
+		newValue, newOK := newRun.Percentiles[key]
+		if !oldOK || !newOK {
+			continue
+		}
+		delta := pctChange(oldValue, newValue)
+		c.Latencies = append(c.Latencies, CompareDelta{key, oldValue, newValue, delta})
+		if delta > tolerances.LatencyPct {
+			c.Regressions = append(c.Regressions, fmt.Sprintf("%s rose %.1f%% (tolerance %g%%)", key, delta, tolerances.LatencyPct))
+		}
+	}
+
+	c.Z, c.P = mannWhitneyU(oldRun.SamplesMs, newRun.SamplesMs)
+	c.OldSamples, c.NewSamples = len(oldRun.SamplesMs), len(newRun.SamplesMs)
+
+	return c
+}
@@ -0,0 +1,57 @@
+package thrash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		z, p float64
+	}{
+		{"b larger", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.6112, 0.0090},
+		{"b smaller", []float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, -2.6112, 0.0090},
+		{"ties", []float64{1, 2, 2, 3}, []float64{2, 3, 3, 4}, 1.5174, 0.1292},
+		{"identical", []float64{1, 1, 1}, []float64{1, 1, 1}, 0, 1},
+		{"empty", nil, []float64{1, 2}, 0, 1},
+	}
+	for _, test := range tests {
+		z, p := mannWhitneyU(test.a, test.b)
+		if math.Abs(z-test.z) > 0.001 || math.Abs(p-test.p) > 0.001 {
+			t.Errorf("%s: got z=%.4f p=%.4f, want z=%.4f p=%.4f", test.name, z, p, test.z, test.p)
+		}
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	oldRun := &SavedRun{
+		RequestsPerSecond: 100,
+		ErrorRate:         0.01,
+		Percentiles:       map[string]float64{"p50": 10, "p99": 50},
+		SamplesMs:         []float64{9, 10, 10, 11, 50},
+	}
+	tolerances := CompareTolerances{LatencyPct: 10, ThroughputPct: 5, ErrorRatePts: 1, Alpha: 0.05}
+
+	if c := CompareRuns(oldRun, oldRun, tolerances); len(c.Regressions) != 0 || c.P != 1 {
+		t.Errorf("comparing a run with itself found %v with p %g", c.Regressions, c.P)
+	}
+
+	newRun := &SavedRun{
+		RequestsPerSecond: 90,
+		ErrorRate:         0.03,
+		Percentiles:       map[string]float64{"p50": 10.5, "p99": 80},
+		SamplesMs:         []float64{10, 11, 12, 13, 80},
+	}
+	c := CompareRuns(oldRun, newRun, tolerances)
+	if len(c.Regressions) != 3 {
+		t.Errorf("got regressions %v, want throughput, error rate and p99", c.Regressions)
+	}
+	if c.Throughput.Delta != -10 || math.Abs(c.ErrorRate.Delta-2) > 1e-9 || c.OldSamples != 5 || c.NewSamples != 5 {
+		t.Errorf("got throughput %+v, error rate %+v, %d vs %d samples", c.Throughput, c.ErrorRate, c.OldSamples, c.NewSamples)
+	}
+	if len(c.Latencies) != 3 || c.Latencies[2].Name != "p99" || c.Latencies[2].Delta != 60 {
+		t.Errorf("got latencies %+v, want avg, p50 and p99 up 60%%", c.Latencies)
+	}
+}
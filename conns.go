@@ -0,0 +1,76 @@
+package thrash
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ConnRecycler retires connections after they have served MaxRequests
+// requests or have been open for MaxAge, whichever comes first, so that a
+// run sees a steady rate of new connections instead of reusing the same pool
+// throughout. A zero limit is not enforced.
+type ConnRecycler struct {
+	MaxRequests int
+	MaxAge      time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connUsage
+}
+
+type connUsage struct {
+	opened   time.Time
+	requests int
+}
+
+// NewConnRecycler returns a ConnRecycler for config, or nil if neither limit
+// is set.
+func NewConnRecycler(config Configuration) *ConnRecycler {
+	if config.ConnMaxRequests <= 0 && config.ConnMaxAge <= 0 {
+		return nil
+	}
+	return &ConnRecycler{
+		MaxRequests: config.ConnMaxRequests,
+		MaxAge:      config.ConnMaxAge,
+		conns:       map[net.Conn]*connUsage{},
+	}
+}
+
+// use counts a request on conn and reports whether it should be the last one.
+func (c *ConnRecycler) use(conn net.Conn, reused bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	usage := c.conns[conn]
+	if usage == nil || !reused {
+		usage = &connUsage{opened: time.Now()}
+		c.conns[conn] = usage
+	}
+	usage.requests++
+
+	retire := (c.MaxRequests > 0 && usage.requests >= c.MaxRequests) ||
+		(c.MaxAge > 0 && time.Since(usage.opened) >= c.MaxAge)
+	if retire {
+		delete(c.conns, conn)
+	}
+	return retire
+}
+
+// traceRequest arranges for req to close its connection once the response
+// has been read if the connection it is sent on is due to be retired.
+func (c *ConnRecycler) traceRequest(req *http.Request, response *Response) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if c.use(info.Conn, info.Reused) {
+				// The hook runs before the request is written, so the header
+				// still makes it onto the wire and the server closes the
+				// connection after responding.
+				req.Header.Set("Connection", "close")
+				response.ConnRecycled = true
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
@@ -0,0 +1,118 @@
+package thrash
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookie modes.
+const (
+	COOKIES_OFF    = "off"
+	COOKIES_SHARED = "shared"
+	COOKIES_USER   = "user"
+)
+
+// Prefix Netscape cookie files put on the domain of HttpOnly cookies.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieMode returns the cookie mode for config. By default virtual users
+// each keep their own cookies, a run with a cookie file shares one jar and
+// any other run ignores cookies.
+func CookieMode(config Configuration) string {
+	switch {
+	case config.Cookies != "":
+		return config.Cookies
+	case config.Users > 0:
+		return COOKIES_USER
+	case config.CookieFile != "":
+		return COOKIES_SHARED
+	}
+	return COOKIES_OFF
+}
+
+// NewCookieJar returns a cookie jar seeded from config.CookieFile, or nil if
+// cookies are off.
+func NewCookieJar(config Configuration) (http.CookieJar, error) {
+	switch CookieMode(config) {
+	case COOKIES_OFF:
+		return nil, nil
+	case COOKIES_SHARED, COOKIES_USER:
+	default:
+		return nil, fmt.Errorf("unknown cookie mode %q", config.Cookies)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.CookieFile != "" {
+		if err := LoadCookieFile(jar, config.CookieFile); err != nil {
+			return nil, err
+		}
+	}
+	return jar, nil
+}
+
+// LoadCookieFile adds the cookies in a Netscape format cookie file, as
+// written by curl and browser extensions, to jar. Expired cookies are
+// skipped.
+func LoadCookieFile(jar http.CookieJar, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = line[len(httpOnlyPrefix):]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: want 7 tab separated fields, got %d", path, n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad expiry %q", path, n, fields[4])
+		}
+
+		host := strings.TrimPrefix(fields[0], ".")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   fields[3] == "TRUE",
+			HttpOnly: httpOnly,
+		}
+		if fields[1] == "TRUE" {
+			cookie.Domain = host
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
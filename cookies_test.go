@@ -0,0 +1,108 @@
+package thrash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/cookiejar"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCookieFile(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func cookieNames(jar *cookiejar.Jar, rawurl string) string {
+	target, _ := url.Parse(rawurl)
+	var names []string
+	for _, cookie := range jar.Cookies(target) {
+		names = append(names, cookie.Name+"="+cookie.Value)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestLoadCookieFile(t *testing.T) {
+	future := fmt.Sprint(time.Now().Add(time.Hour).Unix())
+	past := fmt.Sprint(time.Now().Add(-time.Hour).Unix())
+	path := writeCookieFile(t,
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tFALSE\t"+future+"\tdomain\tall",
+		"www.example.com\tFALSE\t/\tFALSE\t0\thost\tonly",
+		"www.example.com\tFALSE\t/\tTRUE\t0\tsecure\tyes",
+		"www.example.com\tFALSE\t/account\tFALSE\t0\tpath\taccount",
+		"#HttpOnly_www.example.com\tFALSE\t/\tFALSE\t0\tsession\tabc",
+		"www.example.com\tFALSE\t/\tFALSE\t"+past+"\texpired\tgone",
+	)
+
+	jar, _ := cookiejar.New(nil)
+	if err := LoadCookieFile(jar, path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://www.example.com/", "domain=all host=only session=abc"},
+		{"https://www.example.com/account/orders", "domain=all host=only path=account secure=yes session=abc"},
+		{"http://api.example.com/", "domain=all"},
+		{"http://example.org/", ""},
+	}
+	for _, test := range tests {
+		if got := cookieNames(jar, test.url); got != test.want {
+			t.Errorf("cookies for %s: got %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestLoadCookieFileErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"example.com\tFALSE\t/\tFALSE\t0\tname", "cookies.txt:2: want 7 tab separated fields, got 6"},
+		{"example.com\tFALSE\t/\tFALSE\tnever\tname\tvalue", `cookies.txt:2: bad expiry "never"`},
+	}
+	for _, test := range tests {
+		path := writeCookieFile(t, "# Netscape HTTP Cookie File", test.line)
+		jar, _ := cookiejar.New(nil)
+		err := LoadCookieFile(jar, path)
+		if err == nil || !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("got error %v, want %q", err, test.want)
+		}
+	}
+
+	jar, _ := cookiejar.New(nil)
+	if err := LoadCookieFile(jar, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+}
+
+func TestCookieMode(t *testing.T) {
+	tests := []struct {
+		config Configuration
+		want   string
+	}{
+		{Configuration{}, COOKIES_OFF},
+		{Configuration{Users: 10}, COOKIES_USER},
+		{Configuration{CookieFile: "cookies.txt"}, COOKIES_SHARED},
+		{Configuration{Users: 10, CookieFile: "cookies.txt"}, COOKIES_USER},
+		{Configuration{Users: 10, Cookies: COOKIES_SHARED}, COOKIES_SHARED},
+		{Configuration{CookieFile: "cookies.txt", Cookies: COOKIES_OFF}, COOKIES_OFF},
+	}
+	for i, test := range tests {
+		if got := CookieMode(test.config); got != test.want {
+			t.Errorf("case %d: CookieMode = %q, want %q", i, got, test.want)
+		}
+	}
+}
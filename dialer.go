@@ -0,0 +1,240 @@
+package thrash
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ConnectTo redirects connections for Host:Port to ConnectHost:ConnectPort,
+// like curl's --connect-to. An empty Host or Port matches any, and an empty
+// ConnectHost or ConnectPort keeps the original.
+type ConnectTo struct {
+	Host        string
+	Port        string
+	ConnectHost string
+	ConnectPort string
+}
+
+// Dialer opens connections on behalf of the executors. If UnixSocket is set
+// every connection is made to that socket. Otherwise it applies the ConnectTo
+// rules and the Resolve overrides, keyed by host:port, and with RoundRobin set
+// spreads successive connections across every address a host resolves to
+// instead of always preferring the first. Only the address that is dialed
+// changes, so the Host header and TLS server name are unaffected. Successive
+// connections are bound to each of the SourceAddrs in turn.
+type Dialer struct {
+	net.Dialer
+	UnixSocket  string
+	Resolve     map[string][]string
+	ConnectTo   []ConnectTo
+	RoundRobin  bool
+	SourceAddrs []net.IP
+
+	mu         sync.Mutex
+	next       map[string]int
+	nextSource int
+}
+
+// splitFields splits s on colons that are not inside brackets, so IPv6
+// addresses can be given as [::1].
+func splitFields(s string) []string {
+	var fields []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
+
+func unbracket(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// sourceAddrs returns the local addresses for source, either an IP address or
+// the name of an interface whose global and loopback addresses are used.
+func sourceAddrs(source string) ([]net.IP, error) {
+	if ip := net.ParseIP(unbracket(source)); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("bad source %q: not an ip address or interface", source)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && (ipnet.IP.IsGlobalUnicast() || ipnet.IP.IsLoopback()) {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable addresses", source)
+	}
+	return ips, nil
+}
+
+// NewDialer returns a Dialer for config's unix socket, source addresses, dial
+// timeout or -resolve and -connect-to style rules, or nil if none of them are
+// set and round robin is off.
+func NewDialer(config Configuration) (*Dialer, error) {
+	if config.UnixSocket == "" && len(config.Resolve) == 0 && len(config.ConnectTo) == 0 &&
+		!config.RoundRobin && len(config.SourceAddrs) == 0 && config.DialTimeout == 0 {
+		return nil, nil
+	}
+
+	d := &Dialer{
+		UnixSocket: config.UnixSocket,
+		Resolve:    map[string][]string{},
+		RoundRobin: config.RoundRobin || len(config.Resolve) > 0,
+		next:       map[string]int{},
+	}
+	d.Timeout = config.Timeout
+	if config.DialTimeout > 0 {
+		d.Timeout = config.DialTimeout
+	}
+
+	for _, entry := range config.Resolve {
+		fields := splitFields(entry)
+		if len(fields) != 3 || fields[0] == "" || fields[1] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("bad resolve entry %q (want host:port:addr[,addr...])", entry)
+		}
+		key := net.JoinHostPort(unbracket(fields[0]), fields[1])
+		for _, addr := range strings.Split(fields[2], ",") {
+			d.Resolve[key] = append(d.Resolve[key], unbracket(addr))
+		}
+	}
+
+	for _, source := range config.SourceAddrs {
+		ips, err := sourceAddrs(source)
+		if err != nil {
+			return nil, err
+		}
+		d.SourceAddrs = append(d.SourceAddrs, ips...)
+	}
+
+	for _, entry := range config.ConnectTo {
+		fields := splitFields(entry)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("bad connect-to entry %q (want host:port:connect-host:connect-port)", entry)
+		}
+		d.ConnectTo = append(d.ConnectTo, ConnectTo{
+			Host:        unbracket(fields[0]),
+			Port:        fields[1],
+			ConnectHost: unbracket(fields[2]),
+			ConnectPort: fields[3],
+		})
+	}
+
+	return d, nil
+}
+
+// target applies the ConnectTo rules to host and port.
+func (d *Dialer) target(host string, port string) (string, string) {
+	for _, rule := range d.ConnectTo {
+		if (rule.Host == "" || rule.Host == host) && (rule.Port == "" || rule.Port == port) {
+			if rule.ConnectHost != "" {
+				host = rule.ConnectHost
+			}
+			if rule.ConnectPort != "" {
+				port = rule.ConnectPort
+			}
+			break
+		}
+	}
+	return host, port
+}
+
+// source returns a copy of the dialer bound to the next source address for
+// network. If host is an IP address, source addresses of the other family
+// are skipped.
+func (d *Dialer) source(network string, host string) *net.Dialer {
+	dialer := d.Dialer
+	if len(d.SourceAddrs) == 0 {
+		return &dialer
+	}
+
+	target := net.ParseIP(host)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for range d.SourceAddrs {
+		ip := d.SourceAddrs[d.nextSource%len(d.SourceAddrs)]
+		d.nextSource++
+		if target == nil || (target.To4() == nil) == (ip.To4() == nil) {
+			if strings.HasPrefix(network, "udp") {
+				dialer.LocalAddr = &net.UDPAddr{IP: ip}
+			} else {
+				dialer.LocalAddr = &net.TCPAddr{IP: ip}
+			}
+			break
+		}
+	}
+	return &dialer
+}
+
+// pick returns the address to use for the next connection to key.
+func (d *Dialer) pick(key string, addrs []string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	addr := addrs[d.next[key]%len(addrs)]
+	d.next[key]++
+	return addr
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if d.UnixSocket != "" {
+		if strings.HasPrefix(network, "udp") {
+			return d.Dialer.DialContext(ctx, "unixgram", d.UnixSocket)
+		}
+		return d.Dialer.DialContext(ctx, "unix", d.UnixSocket)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	host, port = d.target(host, port)
+	key := net.JoinHostPort(host, port)
+
+	addrs := d.Resolve[key]
+	if addrs == nil && d.RoundRobin && net.ParseIP(host) == nil {
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(addrs) > 0 {
+		host = d.pick(key, addrs)
+	}
+
+	return d.source(network, host).DialContext(ctx, network, net.JoinHostPort(host, port))
+}
+
+// localIP returns the local IP address of conn, without the port, or "" for
+// connections that are not over TCP or UDP.
+func localIP(conn net.Conn) string {
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+	return ""
+}
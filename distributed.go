@@ -0,0 +1,303 @@
+package thrash
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_WORKER_ADDR = "localhost:7000"
+
+// Environment variable the worker token is read from by default, to keep it
+// out of process listings.
+const WORKER_TOKEN_ENV = "THRASH_WORKER_TOKEN"
+
+// How far in the future the controller schedules the start of a distributed
+// run, giving every worker time to receive its job. Workers start at the same
+// wall clock time, so their clocks are assumed to be synchronized.
+const WORKER_START_DELAY = 2 * time.Second
+
+// How often workers stream interval results back to the controller.
+const WORKER_INTERVAL = time.Second
+
+// WorkerJob is sent by the controller to start a run on a worker.
+type WorkerJob struct {
+	Config   Configuration
+	StartAt  time.Time
+	Interval time.Duration
+}
+
+// WorkerMessage is one line of the newline delimited JSON stream a worker
+// sends back while running a job.
+type WorkerMessage struct {
+	Interval *IntervalStats `json:",omitempty"`
+	Error    string         `json:",omitempty"`
+	Done     bool           `json:",omitempty"`
+}
+
+// splitEvenly divides total into n parts that differ by at most one.
+func splitEvenly(total int, n int) []int {
+	parts := make([]int, n)
+	for i := range parts {
+		parts[i] = total / n
+		if i < total%n {
+			parts[i]++
+		}
+	}
+	return parts
+}
+
+// hostOptions returns the options set in config that read files or run
+// commands on the host running them. Workers refuse jobs that set any, as
+// anyone able to reach a worker could otherwise read its files or run
+// commands on it.
+func hostOptions(config Configuration) []string {
+	var options []string
+	if config.AuthExec != "" {
+		options = append(options, "exec auth")
+	}
+	if config.CookieFile != "" {
+		options = append(options, "cookie file")
+	}
+	if config.TLSCert != "" {
+		options = append(options, "client certificate")
+	}
+	if config.TLSKey != "" {
+		options = append(options, "client key")
+	}
+	if config.TLSCA != "" {
+		options = append(options, "ca bundle")
+	}
+	return options
+}
+
+// workerConfig returns the share of config that the given worker should
+// run, with every option that only makes sense on the controller cleared.
+func workerConfig(config Configuration, numRequests int, concurrency int) Configuration {
+	config.NumRequests = numRequests
+	config.Concurrency = concurrency
+	config.Workers = nil
+	config.WorkerToken = ""
+	config.PrintErrors = false
+	config.Profile = false
+	config.LogRequests = ""
+	config.MetricsAddr = ""
+	config.InfluxUrl = ""
+	config.StatsDAddr = ""
+	config.ReportPath = ""
+	config.JSONPath = ""
+	config.AuthExec = ""
+	config.CookieFile = ""
+	config.TLSCert = ""
+	config.TLSKey = ""
+	config.TLSCA = ""
+	return config
+}
+
+// runDistributed splits the run across config.Workers, starts them in sync
+// and merges their streamed interval results into summary.
+func runDistributed(ctx context.Context, config Configuration, summary *ResponseSummary, progress func(int)) error {
+	if options := hostOptions(config); len(options) > 0 {
+		return fmt.Errorf("%s can't be used in distributed runs", strings.Join(options, ", "))
+	}
+
+	requestShares := splitEvenly(config.NumRequests, len(config.Workers))
+	concurrencyShares := splitEvenly(config.Concurrency, len(config.Workers))
+	userShares := splitEvenly(config.Users, len(config.Workers))
+	startAt := time.Now().Add(WORKER_START_DELAY)
+
+	intervals := make(chan *IntervalStats)
+	errs := make(chan error, len(config.Workers))
+	var wg sync.WaitGroup
+
+	for i, addr := range config.Workers {
+		if requestShares[i] == 0 {
+			continue
+		}
+		concurrency := concurrencyShares[i]
+		if concurrency == 0 {
+			concurrency = 1
+		}
+		job := WorkerJob{
+			Config:   workerConfig(config, requestShares[i], concurrency),
+			StartAt:  startAt,
+			Interval: WORKER_INTERVAL,
+		}
+		// Split the rate in proportion to the requests so the workers finish
+		// together.
+		job.Config.Rate = config.Rate * float64(requestShares[i]) / float64(config.NumRequests)
+		if config.Users > 0 {
+			job.Config.Users = userShares[i]
+			if job.Config.Users == 0 {
+				job.Config.Users = 1
+			}
+		}
+
+		wg.Add(1)
+		go func(addr string, job WorkerJob) {
+			defer wg.Done()
+			if err := runOnWorker(ctx, addr, config.WorkerToken, job, intervals); err != nil {
+				errs <- fmt.Errorf("%s: %v", addr, err)
+			}
+		}(addr, job)
+	}
+
+	go func() {
+		wg.Wait()
+		close(intervals)
+	}()
+
+	for interval := range intervals {
+		summary.MergeInterval(interval)
+		if progress != nil {
+			progress(interval.NumResponses)
+		}
+	}
+
+	close(errs)
+	return <-errs
+}
+
+func runOnWorker(ctx context.Context, addr string, token string, job WorkerJob, intervals chan<- *IntervalStats) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	workerUrl := addr
+	if !strings.Contains(workerUrl, "://") {
+		workerUrl = "http://" + workerUrl
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, workerUrl+"/run", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("worker returned %s", resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message WorkerMessage
+		if err := decoder.Decode(&message); err != nil {
+			return fmt.Errorf("reading results: %v", err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("worker error: %s", message.Error)
+		}
+		if message.Interval != nil {
+			intervals <- message.Interval
+		}
+		if message.Done {
+			return nil
+		}
+	}
+}
+
+// streamSink writes interval stats to the controller's response stream.
+type streamSink struct {
+	encoder *json.Encoder
+	flusher http.Flusher
+}
+
+func (s *streamSink) Push(stats *IntervalStats) error {
+	if err := s.encoder.Encode(WorkerMessage{Interval: stats}); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Worker runs jobs for a controller, one at a time. Serve it on /run. If
+// Token is set, controllers have to send it as a bearer token.
+type Worker struct {
+	Token string
+
+	mu sync.Mutex
+}
+
+func (w *Worker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "POST a job to /run", http.StatusMethodNotAllowed)
+		return
+	}
+	if w.Token != "" {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.Token)) != 1 {
+			http.Error(rw, "bad worker token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var job WorkerJob
+	if err := json.NewDecoder(req.Body).Decode(&job); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if options := hostOptions(job.Config); len(options) > 0 {
+		http.Error(rw, "refusing job with "+strings.Join(options, ", "), http.StatusForbidden)
+		return
+	}
+
+	if !w.mu.TryLock() {
+		http.Error(rw, "worker is busy", http.StatusConflict)
+		return
+	}
+	defer w.mu.Unlock()
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	config := job.Config
+	log.Printf("Job from %s: %d requests at concurrency %d against %s starting at %s",
+		req.RemoteAddr, config.NumRequests, config.Concurrency, config.Url, job.StartAt.Format(time.RFC3339Nano))
+	time.Sleep(time.Until(job.StartAt))
+
+	sink := &streamSink{encoder: json.NewEncoder(rw), flusher: flusher}
+
+	runner, err := NewRunner(config)
+	if err != nil {
+		sink.encoder.Encode(WorkerMessage{Error: err.Error()})
+		log.Printf("Job from %s failed: %v", req.RemoteAddr, err)
+		return
+	}
+
+	aggregator := NewIntervalAggregator([]Sink{sink})
+	aggregator.Start(job.Interval)
+	runner.Observers = []Observer{aggregator}
+	_, err = runner.Run(req.Context())
+	aggregator.Close()
+
+	if err != nil {
+		sink.encoder.Encode(WorkerMessage{Error: err.Error()})
+		log.Printf("Job from %s failed: %v", req.RemoteAddr, err)
+		return
+	}
+
+	sink.encoder.Encode(WorkerMessage{Done: true})
+	flusher.Flush()
+	log.Printf("Job from %s done", req.RemoteAddr)
+}
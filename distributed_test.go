@@ -0,0 +1,225 @@
+package thrash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDistributedRun(t *testing.T) {
+	var hits int64
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		rw.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	var workers []string
+	for i := 0; i < 3; i++ {
+		worker := httptest.NewServer(&Worker{Token: "secret"})
+		defer worker.Close()
+		workers = append(workers, worker.URL)
+	}
+
+	runner := &Runner{Config: Configuration{
+		Url:         target.URL,
+		NumRequests: 31,
+		Concurrency: 3,
+		Timeout:     10 * time.Second,
+		Workers:     workers,
+		WorkerToken: "secret",
+	}}
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hits != 31 {
+		t.Errorf("target got %d requests, want 31", hits)
+	}
+	if summary.NumResponses != 31 || summary.NumOK != 31 || summary.StatusCounts[200] != 31 {
+		t.Errorf("got %d responses, %d ok, status counts %v, want 31 ok", summary.NumResponses, summary.NumOK, summary.StatusCounts)
+	}
+	if len(summary.ResponseTimes) != 31 {
+		t.Errorf("got %d response times, want 31", len(summary.ResponseTimes))
+	}
+	if summary.BytesTransferred != 62 {
+		t.Errorf("got %d bytes, want 62", summary.BytesTransferred)
+	}
+	if summary.ProtoCounts["HTTP/1.1"] != 31 {
+		t.Errorf("got protocol counts %v, want 31 HTTP/1.1", summary.ProtoCounts)
+	}
+	addr := target.Listener.Addr().String()
+	if len(summary.Addrs) != 1 || summary.Addrs[addr] == nil || summary.Addrs[addr].NumOK != 31 {
+		t.Errorf("got addrs %v, want 31 ok from %s", summary.Addrs, addr)
+	}
+}
+
+func TestDistributedRunRejectsHostOptions(t *testing.T) {
+	runner := &Runner{Config: Configuration{
+		Url:         "http://localhost/",
+		NumRequests: 1,
+		Concurrency: 1,
+		Workers:     []string{"localhost:1"},
+		AuthExec:    "echo token",
+	}}
+	if _, err := runner.Run(context.Background()); err == nil {
+		t.Error("run with exec auth on workers succeeded")
+	}
+}
+
+func postJob(t *testing.T, url string, token string, job WorkerJob) int {
+	body, _ := json.Marshal(job)
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWorkerRefusesJobs(t *testing.T) {
+	worker := httptest.NewServer(&Worker{Token: "secret"})
+	defer worker.Close()
+
+	job := WorkerJob{Config: Configuration{Url: "http://localhost:1/", NumRequests: 1, Concurrency: 1}}
+	if code := postJob(t, worker.URL, "", job); code != http.StatusUnauthorized {
+		t.Errorf("job without a token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := postJob(t, worker.URL, "wrong", job); code != http.StatusUnauthorized {
+		t.Errorf("job with the wrong token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	for _, config := range []Configuration{
+		{AuthExec: "touch /tmp/thrash-worker-test"},
+		{CookieFile: "/etc/passwd"},
+		{TLSCert: "client.pem", TLSKey: "client.key"},
+		{TLSCA: "ca.pem"},
+	} {
+		config.Url = "http://localhost:1/"
+		config.NumRequests = 1
+		config.Concurrency = 1
+		if code := postJob(t, worker.URL, "secret", WorkerJob{Config: config}); code != http.StatusForbidden {
+			t.Errorf("job with %v: got %d, want %d", hostOptions(config), code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestWorkerConfig(t *testing.T) {
+	config := workerConfig(Configuration{
+		NumRequests: 100,
+		Concurrency: 10,
+		Workers:     []string{"a", "b"},
+		WorkerToken: "secret",
+		AuthExec:    "echo token",
+		CookieFile:  "cookies.txt",
+		TLSCA:       "ca.pem",
+		LogRequests: "requests.jsonl",
+	}, 50, 5)
+	if config.NumRequests != 50 || config.Concurrency != 5 {
+		t.Errorf("got %d requests at concurrency %d, want 50 at 5", config.NumRequests, config.Concurrency)
+	}
+	if len(config.Workers) != 0 || config.WorkerToken != "" || config.LogRequests != "" {
+		t.Errorf("controller options not cleared: %+v", config)
+	}
+	if options := hostOptions(config); len(options) != 0 {
+		t.Errorf("host options not cleared: %v", options)
+	}
+}
+
+func TestSplitEvenly(t *testing.T) {
+	parts := splitEvenly(10, 3)
+	if len(parts) != 3 || parts[0] != 4 || parts[1] != 3 || parts[2] != 3 {
+		t.Errorf("splitEvenly(10, 3) = %v, want [4 3 3]", parts)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram()
+	values := []time.Duration{0, time.Microsecond, 1500 * time.Microsecond, 20 * time.Millisecond, 20 * time.Millisecond, 3 * time.Second}
+	for _, d := range values {
+		h.record(d)
+	}
+
+	var got []time.Duration
+	h.each(func(d time.Duration, count int64) {
+		for i := int64(0); i < count; i++ {
+			got = append(got, d)
+		}
+	})
+	if len(got) != len(values) {
+		t.Fatalf("got %d values back, want %d", len(got), len(values))
+	}
+	for i, d := range got {
+		if math.Abs(float64(d-values[i])) > float64(values[i])*HISTOGRAM_PRECISION {
+			t.Errorf("value %d: got %v, want %v within %g", i, d, values[i], HISTOGRAM_PRECISION)
+		}
+	}
+}
+
+func TestMergeInterval(t *testing.T) {
+	end := time.Now()
+	interval := func(at time.Time, responseTimes ...time.Duration) *IntervalStats {
+		stats := newIntervalStats(at)
+		stats.Time = at
+		stats.Interval = time.Second
+		for _, d := range responseTimes {
+			stats.NumResponses++
+			stats.NumOK++
+			stats.StatusCounts[200]++
+			stats.SumResponseTimes += d
+			stats.Histogram.record(d)
+			if stats.MinResponseTime == 0 || d < stats.MinResponseTime {
+				stats.MinResponseTime = d
+			}
+			if d > stats.MaxResponseTime {
+				stats.MaxResponseTime = d
+			}
+		}
+		return stats
+	}
+
+	first := interval(end.Add(-time.Second), 10*time.Millisecond, 30*time.Millisecond)
+	second := interval(end, 20*time.Millisecond)
+	second.NumResponses++
+	second.NumErrors++
+	second.ErrorClasses["connection_refused"]++
+	second.ErrorMessages["connection refused"]++
+
+	summary := &ResponseSummary{}
+	summary.MergeInterval(first)
+	summary.MergeInterval(second)
+
+	if summary.NumResponses != 4 || summary.NumOK != 3 || summary.StatusCounts[200] != 3 {
+		t.Errorf("got %d responses, %d ok, status counts %v, want 4 and 3 ok", summary.NumResponses, summary.NumOK, summary.StatusCounts)
+	}
+	if len(summary.Errors) != 1 || summary.ErrorClasses["connection_refused"] != 1 {
+		t.Errorf("got errors %v and classes %v, want one connection_refused", summary.Errors, summary.ErrorClasses)
+	}
+	if summary.MinResponseTime != 10*time.Millisecond || summary.MaxResponseTime != 30*time.Millisecond {
+		t.Errorf("got min %v and max %v, want 10ms and 30ms", summary.MinResponseTime, summary.MaxResponseTime)
+	}
+	if summary.SumResponseTimes != 60*time.Millisecond {
+		t.Errorf("got sum %v, want 60ms", summary.SumResponseTimes)
+	}
+	if !summary.StartTime.Equal(end.Add(-2*time.Second)) || !summary.EndTime.Equal(end) {
+		t.Errorf("got %v to %v, want %v to %v", summary.StartTime, summary.EndTime, end.Add(-2*time.Second), end)
+	}
+	if len(summary.ResponseTimes) != 3 {
+		t.Fatalf("got %d response times, want 3", len(summary.ResponseTimes))
+	}
+	median := summary.Percentiles([]float64{50})[0]
+	if math.Abs(float64(median-20*time.Millisecond)) > float64(20*time.Millisecond)*HISTOGRAM_PRECISION {
+		t.Errorf("got median %v, want 20ms", median)
+	}
+}
@@ -0,0 +1,335 @@
+package thrash
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Executor performs the i'th request of a run and reports its outcome and
+// timing. Executors are called concurrently.
+type Executor interface {
+	Execute(ctx context.Context, i int) *Response
+}
+
+// NewExecutor returns the executor for the scheme of config.Url: tcp:// and
+// udp:// use the socket executors, ws:// and wss:// the WebSocket executor,
+// and everything else is sent over HTTP, as streams if config.Stream is set.
+// With config.Retries set the executor is wrapped in a RetryExecutor.
+func NewExecutor(config Configuration) (Executor, error) {
+	executor, err := newProtocolExecutor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := NewRetryPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		executor = &RetryExecutor{Executor: executor, Policy: policy}
+	}
+	return executor, nil
+}
+
+func newProtocolExecutor(config Configuration) (Executor, error) {
+	target, err := url.Parse(config.Url)
+	if err == nil {
+		switch target.Scheme {
+		case "tcp":
+			return NewTCPExecutor(config)
+		case "udp":
+			return NewUDPExecutor(config)
+		case "ws", "wss":
+			return NewWebSocketExecutor(config)
+		}
+	}
+	if config.Stream {
+		return NewStreamExecutor(config)
+	}
+	return NewHTTPExecutor(config)
+}
+
+// RequestGenerator builds the request issued for the i'th request of a run.
+type RequestGenerator interface {
+	NewRequest(ctx context.Context, i int) (*http.Request, error)
+}
+
+// RequestGeneratorFunc adapts a function to a RequestGenerator.
+type RequestGeneratorFunc func(ctx context.Context, i int) (*http.Request, error)
+
+func (f RequestGeneratorFunc) NewRequest(ctx context.Context, i int) (*http.Request, error) {
+	return f(ctx, i)
+}
+
+// NewRequestGenerator returns the default generator, which sends a GET to
+// config.Url with the configured headers and basic auth, unless the username
+// and password are for digest auth.
+func NewRequestGenerator(config Configuration) RequestGenerator {
+	return RequestGeneratorFunc(func(ctx context.Context, i int) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", config.Url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.Username != "" && config.Password != "" && !config.Digest {
+			req.SetBasicAuth(config.Username, config.Password)
+		}
+
+		for key, value := range config.Headers {
+			req.Header.Add(key, value)
+		}
+		return req, nil
+	})
+}
+
+// SupportedProtocols are the values accepted for Configuration.Protocol:
+// HTTP/1.1 only, HTTP/2 over TLS only, cleartext HTTP/2 with prior knowledge,
+// or HTTP/1.1 and HTTP/2 negotiated by ALPN, which is also what an empty
+// Protocol means.
+var SupportedProtocols = []string{"http1", "http2", "h2c", "auto"}
+
+func transportProtocols(name string) *http.Protocols {
+	protocols := &http.Protocols{}
+	switch name {
+	case "http1":
+		protocols.SetHTTP1(true)
+	case "http2":
+		protocols.SetHTTP2(true)
+	case "h2c":
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	return protocols
+}
+
+func NewClient(config Configuration) (*http.Client, error) {
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          config.Concurrency,
+		MaxIdleConnsPerHost:   config.Concurrency,
+		MaxConnsPerHost:       config.MaxConns,
+		DisableKeepAlives:     config.DisableKeepAlives,
+		TLSHandshakeTimeout:   config.TLSTimeout,
+		ResponseHeaderTimeout: config.HeaderTimeout,
+		IdleConnTimeout:       config.IdleTimeout,
+		Protocols:             transportProtocols(config.Protocol),
+	}
+	dialer, err := NewDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		tr.DialContext = dialer.DialContext
+	}
+	if tr.Proxy, err = NewProxyFunc(config); err != nil {
+		return nil, err
+	}
+	if config.StrictStreams {
+		tr.HTTP2 = &http.HTTP2Config{StrictMaxConcurrentRequests: true}
+	}
+	jar, err := NewCookieJar(config)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: tr, Jar: jar, Timeout: config.Timeout}, nil
+}
+
+// HTTPExecutor sends the requests built by Generator with Client. If
+// Recycler is set it decides when connections are retired. Proxied records
+// the proxy tunnel setup time when Client sends through a proxy. A non-zero
+// BodyTimeout limits the time to read the body once the headers are in.
+type HTTPExecutor struct {
+	Client      *http.Client
+	Generator   RequestGenerator
+	Recycler    *ConnRecycler
+	Proxied     bool
+	BodyTimeout time.Duration
+	Auth        Authorizer
+	Signer      Signer
+
+	// Inspect, if set, is called with each response and its body, which is
+	// otherwise discarded.
+	Inspect func(resp *http.Response, body []byte)
+}
+
+// NewHTTPExecutor returns an HTTPExecutor using the default client and
+// request generator for config.
+func NewHTTPExecutor(config Configuration) (*HTTPExecutor, error) {
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := NewAuthorizer(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := NewSigner(config)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPExecutor{
+		Client:      client,
+		Generator:   NewRequestGenerator(config),
+		Recycler:    NewConnRecycler(config),
+		Proxied:     config.Proxy != "",
+		BodyTimeout: config.BodyTimeout,
+		Auth:        auth,
+		Signer:      signer,
+	}, nil
+}
+
+// traceRequest attaches an httptrace to req that records the duration of each
+// connection phase, and whether the connection was reused, on response. The
+// transport may finish a dial in the background after the request has been
+// given another connection, so connection phases are only recorded until the
+// request gets its connection.
+func traceRequest(req *http.Request, response *Response) *http.Request {
+	var mu sync.Mutex
+	var dnsStart, connectStart, tlsStart time.Time
+	gotConn := false
+	record := func(f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !gotConn {
+			f()
+		}
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { record(func() { dnsStart = time.Now() }) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			record(func() { response.DNSDuration = time.Since(dnsStart) })
+		},
+		ConnectStart: func(string, string) { record(func() { connectStart = time.Now() }) },
+		ConnectDone: func(network string, addr string, err error) {
+			record(func() {
+				response.ConnectDuration = time.Since(connectStart)
+				response.RemoteAddr = addr
+			})
+		},
+		TLSHandshakeStart: func() { record(func() { tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			record(func() {
+				response.TLSDuration = time.Since(tlsStart)
+				if err == nil {
+					recordTLS(response, state)
+				}
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			gotConn = true
+			response.ConnReused = info.Reused
+			response.NewConnection = !info.Reused
+			response.RemoteAddr = info.Conn.RemoteAddr().String()
+			response.LocalAddr = localIP(info.Conn)
+		},
+		GotFirstResponseByte: func() {
+			response.TimeToFirstByte = time.Since(response.StartTime)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (e *HTTPExecutor) Execute(ctx context.Context, i int) *Response {
+	response := &Response{OK: true}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	req, err := e.Generator.NewRequest(ctx, i)
+	if err == nil && e.Auth != nil {
+		err = e.Auth.Authorize(ctx, req)
+	}
+	if err == nil && e.Signer != nil {
+		err = e.Signer.Sign(req)
+	}
+	if err != nil {
+		response.OK = false
+		response.Error = err
+		response.StartTime = time.Now()
+		response.EndTime = response.StartTime
+		return response
+	}
+
+	response.Url = req.URL.String()
+	req = traceRequest(req, response)
+	if e.Recycler != nil {
+		req = e.Recycler.traceRequest(req, response)
+	}
+	if e.Proxied {
+		req = traceProxy(req, response)
+	}
+	response.CookiesSent = len(req.Cookies())
+	if e.Client.Jar != nil {
+		response.CookiesSent += len(e.Client.Jar.Cookies(req.URL))
+	}
+	response.StartTime = time.Now()
+	resp, err := e.Client.Do(req)
+	response.EndTime = time.Now()
+
+	if err != nil {
+		response.OK = false
+		response.Error = err
+		return response
+	}
+
+	response.Status = resp.Status
+	response.StatusCode = resp.StatusCode
+	response.Proto = resp.Proto
+	response.CookiesSet = len(resp.Cookies())
+	if resp.StatusCode == http.StatusUnauthorized && e.Auth != nil {
+		e.Auth.Unauthorized(resp)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		response.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+
+	if e.BodyTimeout > 0 {
+		timer := time.AfterFunc(e.BodyTimeout, func() { cancel(ErrBodyTimeout) })
+		defer timer.Stop()
+	}
+
+	defer resp.Body.Close()
+	if e.Inspect != nil {
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		response.ContentLength = int64(len(body))
+		if err == nil {
+			e.Inspect(resp, body)
+		}
+	} else {
+		response.ContentLength, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+
+	if err != nil {
+		response.OK = false
+		response.Error = err
+		if context.Cause(ctx) == ErrBodyTimeout {
+			response.Error = ErrBodyTimeout
+		}
+	}
+
+	return response
+}
+
+// Close closes the executor's idle connections.
+func (e *HTTPExecutor) Close() error {
+	e.Client.CloseIdleConnections()
+	return nil
+}
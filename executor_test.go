@@ -0,0 +1,36 @@
+package thrash
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		protocol string
+		want     string
+	}{
+		{"", "HTTP/2.0"},
+		{"auto", "HTTP/2.0"},
+		{"http2", "HTTP/2.0"},
+		{"http1", "HTTP/1.1"},
+	}
+	for _, test := range tests {
+		executor, err := NewHTTPExecutor(Configuration{Url: server.URL, Protocol: test.protocol, TLSInsecure: true, Concurrency: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := executor.Execute(context.Background(), 0)
+		if response.Proto != test.want {
+			t.Errorf("protocol %q: got %s (%v), want %s", test.protocol, response.Proto, response.Error, test.want)
+		}
+		executor.Close()
+	}
+}
@@ -0,0 +1,52 @@
+package thrash
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Relative width of each LatencyHistogram bucket.
+const HISTOGRAM_PRECISION = 0.01
+
+// LatencyHistogram counts response times in log-linear buckets so that
+// histograms from different processes can be merged by adding counts, with
+// any value recovered to within HISTOGRAM_PRECISION.
+type LatencyHistogram struct {
+	Counts map[int]int64 `json:"counts"`
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{Counts: map[int]int64{}}
+}
+
+func histogramBucket(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Log(float64(d))/math.Log1p(HISTOGRAM_PRECISION)) + 1
+}
+
+func histogramBucketValue(bucket int) time.Duration {
+	if bucket == 0 {
+		return 0
+	}
+	return time.Duration(math.Pow(1+HISTOGRAM_PRECISION, float64(bucket-1)+0.5))
+}
+
+func (h *LatencyHistogram) record(d time.Duration) {
+	h.Counts[histogramBucket(d)]++
+}
+
+// each calls fn with the representative value and count of every non-empty
+// bucket in ascending order.
+func (h *LatencyHistogram) each(fn func(d time.Duration, count int64)) {
+	buckets := make([]int, 0, len(h.Counts))
+	for bucket := range h.Counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	for _, bucket := range buckets {
+		fn(histogramBucketValue(bucket), h.Counts[bucket])
+	}
+}
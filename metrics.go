@@ -0,0 +1,135 @@
+package thrash
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Upper bounds, in seconds, of the latency histogram buckets.
+var metricsLatencyBuckets = []float64{
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Metrics holds live counters for the current run and renders them in the
+// Prometheus text exposition format.
+type Metrics struct {
+	inFlight int64
+
+	mu               sync.Mutex
+	concurrency      int
+	rate             float64
+	statusCounts     map[int]int64
+	errorCounts      map[string]int64
+	latencyBuckets   []int64
+	latencySum       float64
+	latencyCount     int64
+	bytesTransferred int64
+}
+
+func NewMetrics(config Configuration) *Metrics {
+	return &Metrics{
+		concurrency:    config.Concurrency,
+		rate:           config.Rate,
+		statusCounts:   map[int]int64{},
+		errorCounts:    map[string]int64{},
+		latencyBuckets: make([]int64, len(metricsLatencyBuckets)),
+	}
+}
+
+func (m *Metrics) RequestStarted() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+func (m *Metrics) RequestFinished() {
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+func (m *Metrics) Observe(r *Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.OK == false {
+		m.errorCounts[ClassifyError(r.Error)]++
+		return
+	}
+
+	m.statusCounts[r.StatusCode]++
+	if r.ContentLength != -1 {
+		m.bytesTransferred += r.ContentLength
+	}
+
+	seconds := r.ResponseTime().Seconds()
+	for i, upperBound := range metricsLatencyBuckets {
+		if seconds <= upperBound {
+			m.latencyBuckets[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP thrash_requests_total Completed requests by HTTP status code.")
+	fmt.Fprintln(w, "# TYPE thrash_requests_total counter")
+	codes := make([]int, 0, len(m.statusCounts))
+	for code := range m.statusCounts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "thrash_requests_total{code=\"%d\"} %d\n", code, m.statusCounts[code])
+	}
+
+	fmt.Fprintln(w, "# HELP thrash_errors_total Failed requests by error class.")
+	fmt.Fprintln(w, "# TYPE thrash_errors_total counter")
+	classes := make([]string, 0, len(m.errorCounts))
+	for class := range m.errorCounts {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "thrash_errors_total{class=%q} %d\n", class, m.errorCounts[class])
+	}
+
+	fmt.Fprintln(w, "# HELP thrash_response_bytes_total Response body bytes transferred.")
+	fmt.Fprintln(w, "# TYPE thrash_response_bytes_total counter")
+	fmt.Fprintf(w, "thrash_response_bytes_total %d\n", m.bytesTransferred)
+
+	fmt.Fprintln(w, "# HELP thrash_request_duration_seconds Response time of successful requests.")
+	fmt.Fprintln(w, "# TYPE thrash_request_duration_seconds histogram")
+	for i, upperBound := range metricsLatencyBuckets {
+		fmt.Fprintf(w, "thrash_request_duration_seconds_bucket{le=\"%g\"} %d\n", upperBound, m.latencyBuckets[i])
+	}
+	fmt.Fprintf(w, "thrash_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(w, "thrash_request_duration_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "thrash_request_duration_seconds_count %d\n", m.latencyCount)
+
+	fmt.Fprintln(w, "# HELP thrash_requests_in_flight Requests currently waiting on a response.")
+	fmt.Fprintln(w, "# TYPE thrash_requests_in_flight gauge")
+	fmt.Fprintf(w, "thrash_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	fmt.Fprintln(w, "# HELP thrash_target_concurrency Configured number of concurrent requests.")
+	fmt.Fprintln(w, "# TYPE thrash_target_concurrency gauge")
+	fmt.Fprintf(w, "thrash_target_concurrency %d\n", m.concurrency)
+
+	fmt.Fprintln(w, "# HELP thrash_target_rps Configured request rate, 0 when unlimited.")
+	fmt.Fprintln(w, "# TYPE thrash_target_rps gauge")
+	fmt.Fprintf(w, "thrash_target_rps %g\n", m.rate)
+}
+
+func StartMetricsServer(addr string, metrics *Metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		log.Println(http.ListenAndServe(addr, mux))
+	}()
+}
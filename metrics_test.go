@@ -0,0 +1,35 @@
+package thrash
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(Configuration{Concurrency: 8, Rate: 250})
+	start := time.Now()
+	metrics.RequestStarted()
+	metrics.Observe(&Response{OK: true, StatusCode: 200, ContentLength: 100, StartTime: start, EndTime: start.Add(20 * time.Millisecond)})
+	metrics.Observe(&Response{OK: false, Error: errors.New("boom")})
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`thrash_requests_total{code="200"} 1`,
+		`thrash_errors_total{class="other"} 1`,
+		`thrash_response_bytes_total 100`,
+		`thrash_request_duration_seconds_bucket{le="0.025"} 1`,
+		`thrash_request_duration_seconds_count 1`,
+		`thrash_requests_in_flight 1`,
+		`thrash_target_concurrency 8`,
+		`thrash_target_rps 250`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package thrash
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// proxySchemes are the proxy URL schemes the transport can send through.
+var proxySchemes = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"socks5":  true,
+	"socks5h": true,
+}
+
+// NewProxyFunc returns the transport proxy function for config.Proxy, or nil
+// when no proxy is set. Credentials in the proxy URL are used to authenticate
+// with the proxy, for CONNECT tunnels as well as plain requests.
+func NewProxyFunc(config Configuration) (func(*http.Request) (*url.URL, error), error) {
+	proxy, err := parseProxy(config)
+	if proxy == nil || err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(proxy), nil
+}
+
+// parseProxy returns config.Proxy parsed, or nil when no proxy is set.
+func parseProxy(config Configuration) (*url.URL, error) {
+	if config.Proxy == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if !proxySchemes[proxy.Scheme] || proxy.Host == "" {
+		return nil, fmt.Errorf("bad proxy %q (want http://, https:// or socks5://host:port)", config.Proxy)
+	}
+	return proxy, nil
+}
+
+// proxyAddr returns the host:port to connect to for proxy.
+func proxyAddr(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	if proxy.Scheme == "https" {
+		return net.JoinHostPort(proxy.Hostname(), "443")
+	}
+	return net.JoinHostPort(proxy.Hostname(), "80")
+}
+
+// connectTunnel asks the HTTP or HTTPS proxy at the other end of conn to
+// open a tunnel to addr with CONNECT, and returns the connection to use
+// through it. tlsConfig is used for the handshake with an HTTPS proxy.
+func connectTunnel(ctx context.Context, conn net.Conn, proxy *url.URL, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if proxy.Scheme == "https" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = proxy.Hostname()
+		tlsConfig.NextProtos = nil
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy refused tunnel to %s: %s", addr, resp.Status)
+	}
+	return conn, nil
+}
+
+// traceProxy records on response how long it took to set up the connection
+// through the proxy: connecting to it and, for CONNECT and SOCKS tunnels,
+// negotiating the tunnel up to the point the request, or the upstream TLS
+// handshake, can start. Requests on reused connections record nothing.
+func traceProxy(req *http.Request, response *Response) *http.Request {
+	var mu sync.Mutex
+	var start time.Time
+	gotConn := false
+	tunnelReady := func() {
+		if !gotConn && !start.IsZero() && response.ProxyDuration == 0 {
+			response.ProxyDuration = time.Since(start)
+		}
+	}
+
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !gotConn && start.IsZero() {
+				start = time.Now()
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			tunnelReady()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !info.Reused {
+				tunnelReady()
+			}
+			gotConn = true
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
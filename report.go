@@ -0,0 +1,449 @@
+package thrash
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const REPORT_TIMELINE_BINS = 60
+const REPORT_HISTOGRAM_BUCKETS = 20
+const REPORT_CHART_WIDTH = 640
+const REPORT_CHART_HEIGHT = 200
+const REPORT_REDACTED = "[redacted]"
+
+// reportSecretHeaders are headers whose values are left out of reports.
+var reportSecretHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Api-Key":            true,
+	"X-Auth-Token":         true,
+	"X-Amz-Security-Token": true,
+}
+
+var reportPalette = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f",
+	"#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac",
+}
+
+type reportField struct {
+	Name  string
+	Value string
+}
+
+type htmlReport struct {
+	Generated    string
+	Config       []reportField
+	Summary      []reportField
+	Percentiles  []reportField
+	Statuses     []reportField
+	Errors       []reportField
+	Histogram    template.HTML
+	LatencyChart template.HTML
+	RPSChart     template.HTML
+	StatusChart  template.HTML
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>thrash report</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em 0.2em 0; text-align: left; }
+td.value { font-family: monospace; }
+svg text { font-size: 11px; fill: #555; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>thrash report</h1>
+<p class="muted">Generated {{.Generated}}</p>
+
+<h2>Configuration</h2>
+<table>{{range .Config}}<tr><th>{{.Name}}</th><td class="value">{{.Value}}</td></tr>{{end}}</table>
+
+<h2>Summary</h2>
+<table>{{range .Summary}}<tr><th>{{.Name}}</th><td class="value">{{.Value}}</td></tr>{{end}}</table>
+
+<h2>Percentiles</h2>
+<table>{{range .Percentiles}}<tr><th>{{.Name}}</th><td class="value">{{.Value}}</td></tr>{{end}}</table>
+
+<h2>Response Time Histogram</h2>
+{{.Histogram}}
+
+<h2>Response Time Over Time</h2>
+{{.LatencyChart}}
+
+<h2>Requests Per Second Over Time</h2>
+{{.RPSChart}}
+
+<h2>Status Codes</h2>
+{{.StatusChart}}
+<table>{{range .Statuses}}<tr><th>{{.Name}}</th><td class="value">{{.Value}}</td></tr>{{end}}</table>
+
+<h2>Errors</h2>
+{{if .Errors}}<table>{{range .Errors}}<tr><th>{{.Name}}</th><td class="value">{{.Value}}</td></tr>{{end}}</table>{{else}}<p class="muted">No errors</p>{{end}}
+</body>
+</html>
+`))
+
+// HTMLReporter writes an html report to Path.
+type HTMLReporter struct {
+	Path string
+}
+
+func (r *HTMLReporter) Report(config Configuration, summary *ResponseSummary) error {
+	return WriteHTMLReport(r.Path, config, summary)
+}
+
+// WriteHTMLReport renders the summary as a single self-contained HTML file
+// with inline SVG charts.
+func WriteHTMLReport(path string, config Configuration, s *ResponseSummary) error {
+	report := htmlReport{
+		Generated:    time.Now().Format(time.RFC1123),
+		Config:       reportConfig(config),
+		Summary:      reportSummary(s),
+		Percentiles:  reportPercentileFields(s),
+		Statuses:     reportStatuses(s),
+		Errors:       reportErrors(s),
+		Histogram:    reportHistogram(s),
+		LatencyChart: reportLatencyChart(s),
+		RPSChart:     reportRPSChart(s),
+		StatusChart:  reportStatusChart(s),
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(file, report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func reportConfig(config Configuration) []reportField {
+	fields := []reportField{
+		{"URL", redactURL(config.Url)},
+		{"Concurrency", fmt.Sprint(config.Concurrency)},
+		{"Num Requests", fmt.Sprint(config.NumRequests)},
+		{"Timeout", config.Timeout.String()},
+	}
+	if config.Username != "" {
+		fields = append(fields, reportField{"Basic Auth User", config.Username})
+	}
+	keys := make([]string, 0, len(config.Headers))
+	for key := range config.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := config.Headers[key]
+		if reportSecretHeaders[http.CanonicalHeaderKey(key)] {
+			value = REPORT_REDACTED
+		}
+		fields = append(fields, reportField{"Header " + key, value})
+	}
+	return fields
+}
+
+// redactURL hides any password in rawurl.
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return u.Redacted()
+}
+
+func reportSummary(s *ResponseSummary) []reportField {
+	duration := s.EndTime.Sub(s.StartTime)
+	pctOK := 0.0
+	avgResponseTime := time.Duration(0)
+	if s.NumResponses != 0 {
+		pctOK = float64(s.NumOK) / float64(s.NumResponses) * 100
+	}
+	if s.NumOK != 0 {
+		avgResponseTime = time.Duration(float64(s.SumResponseTimes) / float64(s.NumOK))
+	}
+	rps := 0.0
+	if duration > 0 {
+		rps = float64(s.NumResponses) / duration.Seconds()
+	}
+	var fields []reportField
+	if s.AbortReason != "" {
+		fields = append(fields, reportField{"Aborted", s.AbortReason})
+	}
+	return append(fields, []reportField{
+		{"Responses OK", fmt.Sprintf("%.2f%% (%d/%d)", pctOK, s.NumOK, s.NumResponses)},
+		{"Errors", fmt.Sprint(len(s.Errors))},
+		{"Duration", duration.String()},
+		{"Requests Per Second", fmt.Sprintf("%.2f", rps)},
+		{"Bytes Transferred", fmt.Sprint(s.BytesTransferred)},
+		{"Avg Response Time", avgResponseTime.String()},
+		{"Min Response Time", s.MinResponseTime.String()},
+		{"Max Response Time", s.MaxResponseTime.String()},
+	}...)
+}
+
+func reportPercentileFields(s *ResponseSummary) []reportField {
+	values := s.Percentiles(summaryPercentiles)
+	fields := make([]reportField, len(values))
+	for i, value := range values {
+		fields[i] = reportField{percentileKey(summaryPercentiles[i]), value.String()}
+	}
+	return fields
+}
+
+func reportStatuses(s *ResponseSummary) []reportField {
+	var fields []reportField
+	for _, code := range sortedStatusCodes(s.StatusCounts) {
+		fields = append(fields, reportField{fmt.Sprint(code), fmt.Sprint(s.StatusCounts[code])})
+	}
+	return fields
+}
+
+func reportErrors(s *ResponseSummary) []reportField {
+	classes := make([]string, 0, len(s.ErrorClasses))
+	for class := range s.ErrorClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	var fields []reportField
+	for _, class := range classes {
+		fields = append(fields, reportField{class, fmt.Sprint(s.ErrorClasses[class])})
+	}
+
+	// Show a handful of distinct messages so the classes can be diagnosed.
+	seen := map[string]bool{}
+	for _, err := range s.Errors {
+		message := err.Error()
+		if seen[message] || len(seen) == 10 {
+			continue
+		}
+		seen[message] = true
+		fields = append(fields, reportField{"example", message})
+	}
+	return fields
+}
+
+func sortedStatusCodes(counts map[int]int) []int {
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+func reportHistogram(s *ResponseSummary) template.HTML {
+	if len(s.ResponseTimes) == 0 {
+		return noDataChart()
+	}
+
+	buckets := make([]float64, REPORT_HISTOGRAM_BUCKETS)
+	bucketLength := float64(s.MaxResponseTime-s.MinResponseTime) / REPORT_HISTOGRAM_BUCKETS
+	for _, responseTime := range s.ResponseTimes {
+		bucket := 0
+		if bucketLength > 0 {
+			bucket = int(float64(responseTime-s.MinResponseTime) / bucketLength)
+		}
+		if bucket >= REPORT_HISTOGRAM_BUCKETS {
+			bucket = REPORT_HISTOGRAM_BUCKETS - 1
+		}
+		buckets[bucket]++
+	}
+
+	maxCount := maxFloat(buckets)
+	barWidth := float64(REPORT_CHART_WIDTH) / REPORT_HISTOGRAM_BUCKETS
+
+	var svg strings.Builder
+	startChart(&svg)
+	for i, count := range buckets {
+		height := count / maxCount * REPORT_CHART_HEIGHT
+		fmt.Fprintf(&svg, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%d</title></rect>`,
+			float64(i)*barWidth+1, REPORT_CHART_HEIGHT-height, barWidth-2, height, reportPalette[0], int(count))
+	}
+	endChart(&svg, s.MinResponseTime.String(), s.MaxResponseTime.String(), fmt.Sprintf("%d responses", int(maxCount)))
+	return template.HTML(svg.String())
+}
+
+// timelineBins splits the run into equal bins and returns the bin width and
+// the bin index of t.
+func timelineBins(s *ResponseSummary) (time.Duration, func(t time.Time) int) {
+	width := s.EndTime.Sub(s.StartTime) / REPORT_TIMELINE_BINS
+	if width <= 0 {
+		width = time.Millisecond
+	}
+	return width, func(t time.Time) int {
+		bin := int(t.Sub(s.StartTime) / width)
+		if bin >= REPORT_TIMELINE_BINS {
+			bin = REPORT_TIMELINE_BINS - 1
+		}
+		if bin < 0 {
+			bin = 0
+		}
+		return bin
+	}
+}
+
+func reportLatencyChart(s *ResponseSummary) template.HTML {
+	if len(s.ResponseTimes) == 0 {
+		return noDataChart()
+	}
+
+	_, binOf := timelineBins(s)
+	sums := make([]float64, REPORT_TIMELINE_BINS)
+	counts := make([]float64, REPORT_TIMELINE_BINS)
+	for i, responseTime := range s.ResponseTimes {
+		bin := binOf(s.ResponseEndTimes[i])
+		sums[bin] += float64(responseTime)
+		counts[bin]++
+	}
+	averages := make([]float64, REPORT_TIMELINE_BINS)
+	for i := range averages {
+		if counts[i] > 0 {
+			averages[i] = sums[i] / counts[i]
+		} else {
+			averages[i] = math.NaN()
+		}
+	}
+
+	return lineChart(averages, time.Duration(maxFloat(averages)).String(), s.EndTime.Sub(s.StartTime).String())
+}
+
+func reportRPSChart(s *ResponseSummary) template.HTML {
+	if s.NumResponses == 0 {
+		return noDataChart()
+	}
+
+	width, binOf := timelineBins(s)
+	rates := make([]float64, REPORT_TIMELINE_BINS)
+	for _, t := range s.ResponseEndTimes {
+		rates[binOf(t)]++
+	}
+	for _, t := range s.ErrorTimes {
+		rates[binOf(t)]++
+	}
+	for i := range rates {
+		rates[i] /= width.Seconds()
+	}
+
+	return lineChart(rates, fmt.Sprintf("%.1f req/s", maxFloat(rates)), s.EndTime.Sub(s.StartTime).String())
+}
+
+func reportStatusChart(s *ResponseSummary) template.HTML {
+	slices := map[string]int{}
+	for code, count := range s.StatusCounts {
+		slices[fmt.Sprint(code)] = count
+	}
+	if len(s.Errors) > 0 {
+		slices["error"] = len(s.Errors)
+	}
+	if len(slices) == 0 {
+		return noDataChart()
+	}
+
+	labels := make([]string, 0, len(slices))
+	total := 0
+	for label, count := range slices {
+		labels = append(labels, label)
+		total += count
+	}
+	sort.Strings(labels)
+
+	const radius = 80.0
+	const cx, cy = 100.0, 100.0
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`, REPORT_CHART_WIDTH, 200)
+	angle := -math.Pi / 2
+	for i, label := range labels {
+		color := reportPalette[i%len(reportPalette)]
+		fraction := float64(slices[label]) / float64(total)
+		if fraction == 1 {
+			fmt.Fprintf(&svg, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`, cx, cy, radius, color)
+		} else {
+			end := angle + fraction*2*math.Pi
+			largeArc := 0
+			if fraction > 0.5 {
+				largeArc = 1
+			}
+			fmt.Fprintf(&svg, `<path d="M%g,%g L%.2f,%.2f A%g,%g 0 %d 1 %.2f,%.2f Z" fill="%s"/>`,
+				cx, cy,
+				cx+radius*math.Cos(angle), cy+radius*math.Sin(angle),
+				radius, radius, largeArc,
+				cx+radius*math.Cos(end), cy+radius*math.Sin(end),
+				color)
+			angle = end
+		}
+		fmt.Fprintf(&svg, `<rect x="220" y="%d" width="12" height="12" fill="%s"/><text x="240" y="%d">%s (%.1f%%)</text>`,
+			20+i*18, color, 30+i*18, template.HTMLEscapeString(label), fraction*100)
+	}
+	svg.WriteString(`</svg>`)
+	return template.HTML(svg.String())
+}
+
+func lineChart(values []float64, maxLabel string, durationLabel string) template.HTML {
+	maxValue := maxFloat(values)
+	step := float64(REPORT_CHART_WIDTH) / float64(len(values)-1)
+
+	var points []string
+	for i, value := range values {
+		if math.IsNaN(value) {
+			continue
+		}
+		y := float64(REPORT_CHART_HEIGHT)
+		if maxValue > 0 {
+			y -= value / maxValue * REPORT_CHART_HEIGHT
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", float64(i)*step, y))
+	}
+
+	var svg strings.Builder
+	startChart(&svg)
+	fmt.Fprintf(&svg, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), reportPalette[0])
+	endChart(&svg, "0s", durationLabel, maxLabel)
+	return template.HTML(svg.String())
+}
+
+func startChart(svg *strings.Builder) {
+	fmt.Fprintf(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="-10 -20 %d %d">`,
+		REPORT_CHART_WIDTH+20, REPORT_CHART_HEIGHT+40, REPORT_CHART_WIDTH+20, REPORT_CHART_HEIGHT+40)
+	fmt.Fprintf(svg, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, REPORT_CHART_HEIGHT, REPORT_CHART_WIDTH, REPORT_CHART_HEIGHT)
+}
+
+func endChart(svg *strings.Builder, xStart string, xEnd string, yMax string) {
+	fmt.Fprintf(svg, `<text x="0" y="-6">%s</text>`, template.HTMLEscapeString(yMax))
+	fmt.Fprintf(svg, `<text x="0" y="%d">%s</text>`, REPORT_CHART_HEIGHT+15, template.HTMLEscapeString(xStart))
+	fmt.Fprintf(svg, `<text x="%d" y="%d" text-anchor="end">%s</text>`, REPORT_CHART_WIDTH, REPORT_CHART_HEIGHT+15, template.HTMLEscapeString(xEnd))
+	svg.WriteString(`</svg>`)
+}
+
+func noDataChart() template.HTML {
+	return template.HTML(`<p class="muted">No data</p>`)
+}
+
+func maxFloat(values []float64) float64 {
+	max := 0.0
+	for _, value := range values {
+		if value > max {
+			max = value
+		}
+	}
+	return max
+}
@@ -0,0 +1,247 @@
+package thrash
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const REQUEST_LOG_BUFFER = 4096
+
+var requestLogColumns = []string{
+	"intended_start",
+	"start",
+	"end",
+	"url",
+	"remote_addr",
+	"local_addr",
+	"ok",
+	"status_code",
+	"proto",
+	"bytes",
+	"error_class",
+	"error",
+	"dns_ms",
+	"connect_ms",
+	"proxy_ms",
+	"tls_ms",
+	"tls_version",
+	"tls_cipher",
+	"tls_resumed",
+	"ttfb_ms",
+	"handshake_ms",
+	"events",
+	"first_event_ms",
+	"total_ms",
+	"conn_reused",
+	"new_connection",
+	"conn_recycled",
+	"attempts",
+	"cookies_sent",
+	"cookies_set",
+}
+
+// requestLogRecord is the flattened form of a Response written to the
+// request log, one per line.
+type requestLogRecord struct {
+	IntendedStart string  `json:"intended_start"`
+	Start         string  `json:"start"`
+	End           string  `json:"end"`
+	Url           string  `json:"url"`
+	RemoteAddr    string  `json:"remote_addr,omitempty"`
+	LocalAddr     string  `json:"local_addr,omitempty"`
+	OK            bool    `json:"ok"`
+	StatusCode    int     `json:"status_code"`
+	Proto         string  `json:"proto,omitempty"`
+	Bytes         int64   `json:"bytes"`
+	ErrorClass    string  `json:"error_class,omitempty"`
+	Error         string  `json:"error,omitempty"`
+	DNSMs         float64 `json:"dns_ms"`
+	ConnectMs     float64 `json:"connect_ms"`
+	ProxyMs       float64 `json:"proxy_ms"`
+	TLSMs         float64 `json:"tls_ms"`
+	TLSVersion    string  `json:"tls_version,omitempty"`
+	TLSCipher     string  `json:"tls_cipher,omitempty"`
+	TLSResumed    bool    `json:"tls_resumed"`
+	TTFBMs        float64 `json:"ttfb_ms"`
+	HandshakeMs   float64 `json:"handshake_ms"`
+	Events        int     `json:"events"`
+	FirstEventMs  float64 `json:"first_event_ms"`
+	TotalMs       float64 `json:"total_ms"`
+	ConnReused    bool    `json:"conn_reused"`
+	NewConnection bool    `json:"new_connection"`
+	ConnRecycled  bool    `json:"conn_recycled"`
+	Attempts      int     `json:"attempts,omitempty"`
+	CookiesSent   int     `json:"cookies_sent,omitempty"`
+	CookiesSet    int     `json:"cookies_set,omitempty"`
+}
+
+func (r *requestLogRecord) csvRow() []string {
+	return []string{
+		r.IntendedStart,
+		r.Start,
+		r.End,
+		r.Url,
+		r.RemoteAddr,
+		r.LocalAddr,
+		strconv.FormatBool(r.OK),
+		strconv.Itoa(r.StatusCode),
+		r.Proto,
+		strconv.FormatInt(r.Bytes, 10),
+		r.ErrorClass,
+		r.Error,
+		formatMs(r.DNSMs),
+		formatMs(r.ConnectMs),
+		formatMs(r.ProxyMs),
+		formatMs(r.TLSMs),
+		r.TLSVersion,
+		r.TLSCipher,
+		strconv.FormatBool(r.TLSResumed),
+		formatMs(r.TTFBMs),
+		formatMs(r.HandshakeMs),
+		strconv.Itoa(r.Events),
+		formatMs(r.FirstEventMs),
+		formatMs(r.TotalMs),
+		strconv.FormatBool(r.ConnReused),
+		strconv.FormatBool(r.NewConnection),
+		strconv.FormatBool(r.ConnRecycled),
+		strconv.Itoa(r.Attempts),
+		strconv.Itoa(r.CookiesSent),
+		strconv.Itoa(r.CookiesSet),
+	}
+}
+
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 3, 64)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newRequestLogRecord(r *Response) *requestLogRecord {
+	record := &requestLogRecord{
+		IntendedStart: r.IntendedStartTime.Format(time.RFC3339Nano),
+		Start:         r.StartTime.Format(time.RFC3339Nano),
+		End:           r.EndTime.Format(time.RFC3339Nano),
+		Url:           r.Url,
+		RemoteAddr:    r.RemoteAddr,
+		LocalAddr:     r.LocalAddr,
+		OK:            r.OK,
+		StatusCode:    r.StatusCode,
+		Proto:         r.Proto,
+		Bytes:         r.ContentLength,
+		ErrorClass:    ClassifyError(r.Error),
+		DNSMs:         durationMs(r.DNSDuration),
+		ConnectMs:     durationMs(r.ConnectDuration),
+		ProxyMs:       durationMs(r.ProxyDuration),
+		TLSMs:         durationMs(r.TLSDuration),
+		TLSVersion:    r.TLSVersion,
+		TLSCipher:     r.TLSCipher,
+		TLSResumed:    r.TLSResumed,
+		TTFBMs:        durationMs(r.TimeToFirstByte),
+		HandshakeMs:   durationMs(r.HandshakeDuration),
+		Events:        r.NumEvents,
+		FirstEventMs:  durationMs(r.TimeToFirstEvent),
+		TotalMs:       durationMs(r.ResponseTime()),
+		ConnReused:    r.ConnReused,
+		NewConnection: r.NewConnection,
+		ConnRecycled:  r.ConnRecycled,
+		Attempts:      r.Attempts,
+		CookiesSent:   r.CookiesSent,
+		CookiesSet:    r.CookiesSet,
+	}
+	if r.Error != nil {
+		record.Error = r.Error.Error()
+	}
+	return record
+}
+
+// RequestLogger streams every response to a file from a background goroutine
+// so that encoding and disk writes stay off the request path.
+type RequestLogger struct {
+	file    *os.File
+	writer  *bufio.Writer
+	format  string
+	records chan *Response
+	done    chan error
+}
+
+func NewRequestLogger(path string, format string) (*RequestLogger, error) {
+	if format != "jsonl" && format != "csv" {
+		return nil, fmt.Errorf("unknown request log format %q", format)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &RequestLogger{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		format:  format,
+		records: make(chan *Response, REQUEST_LOG_BUFFER),
+		done:    make(chan error, 1),
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *RequestLogger) run() {
+	var err error
+	if l.format == "csv" {
+		err = l.writeCSV()
+	} else {
+		err = l.writeJSONL()
+	}
+	// Drain anything left so Observe never blocks after a write error.
+	for range l.records {
+	}
+	if flushErr := l.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.done <- err
+}
+
+func (l *RequestLogger) writeJSONL() error {
+	encoder := json.NewEncoder(l.writer)
+	for r := range l.records {
+		if err := encoder.Encode(newRequestLogRecord(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *RequestLogger) writeCSV() error {
+	w := csv.NewWriter(l.writer)
+	if err := w.Write(requestLogColumns); err != nil {
+		return err
+	}
+	for r := range l.records {
+		if err := w.Write(newRequestLogRecord(r).csvRow()); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Observe queues a response to be written.
+func (l *RequestLogger) Observe(r *Response) {
+	l.records <- r
+}
+
+// Close flushes all queued responses and closes the log file.
+func (l *RequestLogger) Close() error {
+	close(l.records)
+	return <-l.done
+}
@@ -0,0 +1,53 @@
+package thrash
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogChunkedBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			rw.Write([]byte("chunk"))
+			rw.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	executor, err := NewHTTPExecutor(Configuration{Url: server.URL, Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := executor.Execute(context.Background(), 0)
+	if response.ContentLength != 15 {
+		t.Errorf("got %d bytes, want 15", response.ContentLength)
+	}
+
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	logger, err := NewRequestLogger(path, "jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Observe(response)
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record requestLogRecord
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(data))), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Bytes != 15 || record.StatusCode != 200 || !record.OK {
+		t.Errorf("got %+v, want 15 bytes with status 200", record)
+	}
+}
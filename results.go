@@ -0,0 +1,122 @@
+package thrash
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Cap on the number of response times kept in a saved run; enough for the
+// percentiles and rank tests used by compare without bloating the file.
+const MAX_SAVED_SAMPLES = 10000
+
+// SavedRun is the JSON form of a run written with -json and read back by
+// thrash compare. Durations are in milliseconds.
+type SavedRun struct {
+	Url               string             `json:"url"`
+	Concurrency       int                `json:"concurrency"`
+	NumRequests       int                `json:"num_requests"`
+	StartTime         time.Time          `json:"start_time"`
+	EndTime           time.Time          `json:"end_time"`
+	DurationMs        float64            `json:"duration_ms"`
+	NumResponses      int                `json:"num_responses"`
+	NumOK             int                `json:"num_ok"`
+	NumErrors         int                `json:"num_errors"`
+	BytesTransferred  int64              `json:"bytes_transferred"`
+	RequestsPerSecond float64            `json:"requests_per_second"`
+	ErrorRate         float64            `json:"error_rate"`
+	AvgMs             float64            `json:"avg_ms"`
+	MinMs             float64            `json:"min_ms"`
+	MaxMs             float64            `json:"max_ms"`
+	Percentiles       map[string]float64 `json:"percentiles_ms"`
+	StatusCounts      map[int]int        `json:"status_counts"`
+	ErrorClasses      map[string]int     `json:"error_classes,omitempty"`
+	SamplesMs         []float64          `json:"samples_ms"`
+	Aborted           string             `json:"aborted,omitempty"`
+}
+
+func percentileKey(p float64) string {
+	return fmt.Sprintf("p%g", p)
+}
+
+func NewSavedRun(config Configuration, s *ResponseSummary) *SavedRun {
+	duration := s.EndTime.Sub(s.StartTime)
+	run := &SavedRun{
+		Url:              redactURL(config.Url),
+		Concurrency:      config.Concurrency,
+		NumRequests:      config.NumRequests,
+		StartTime:        s.StartTime,
+		EndTime:          s.EndTime,
+		DurationMs:       durationMs(duration),
+		NumResponses:     s.NumResponses,
+		NumOK:            s.NumOK,
+		NumErrors:        len(s.Errors),
+		BytesTransferred: s.BytesTransferred,
+		MinMs:            durationMs(s.MinResponseTime),
+		MaxMs:            durationMs(s.MaxResponseTime),
+		Percentiles:      map[string]float64{},
+		StatusCounts:     s.StatusCounts,
+		ErrorClasses:     s.ErrorClasses,
+		Aborted:          s.AbortReason,
+	}
+	if duration > 0 {
+		run.RequestsPerSecond = float64(s.NumResponses) / duration.Seconds()
+	}
+	if s.NumResponses > 0 {
+		run.ErrorRate = float64(len(s.Errors)) / float64(s.NumResponses)
+	}
+	if s.NumOK > 0 {
+		run.AvgMs = durationMs(s.SumResponseTimes) / float64(s.NumOK)
+	}
+	for i, value := range s.Percentiles(summaryPercentiles) {
+		run.Percentiles[percentileKey(summaryPercentiles[i])] = durationMs(value)
+	}
+
+	// Take evenly strided samples so long runs are represented end to end.
+	stride := 1
+	if len(s.ResponseTimes) > MAX_SAVED_SAMPLES {
+		stride = (len(s.ResponseTimes) + MAX_SAVED_SAMPLES - 1) / MAX_SAVED_SAMPLES
+	}
+	for i := 0; i < len(s.ResponseTimes); i += stride {
+		run.SamplesMs = append(run.SamplesMs, durationMs(s.ResponseTimes[i]))
+	}
+	return run
+}
+
+func WriteSavedRun(path string, run *SavedRun) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(run); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// JSONReporter saves the run to Path for thrash compare.
+type JSONReporter struct {
+	Path string
+}
+
+func (r *JSONReporter) Report(config Configuration, summary *ResponseSummary) error {
+	return WriteSavedRun(r.Path, NewSavedRun(config, summary))
+}
+
+func LoadSavedRun(path string) (*SavedRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	run := &SavedRun{}
+	if err := json.NewDecoder(file).Decode(run); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return run, nil
+}
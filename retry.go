@@ -0,0 +1,158 @@
+package thrash
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status codes and error classes retried by default.
+const DEFAULT_RETRY_ON = "429,502,503,504,connection_refused,connection_reset,eof"
+
+const DEFAULT_RETRY_BACKOFF = "100ms"
+const DEFAULT_RETRY_MAX_BACKOFF = "10s"
+
+// RetryPolicy decides which outcomes are retried and how long to wait
+// between attempts.
+type RetryPolicy struct {
+	MaxRetries   int
+	Statuses     map[int]bool
+	ErrorClasses map[string]bool
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+}
+
+// NewRetryPolicy returns the policy for config, or nil if retries are off.
+// config.RetryOn lists status codes and ClassifyError classes.
+func NewRetryPolicy(config Configuration) (*RetryPolicy, error) {
+	if config.Retries <= 0 {
+		return nil, nil
+	}
+
+	policy := &RetryPolicy{
+		MaxRetries:   config.Retries,
+		Statuses:     map[int]bool{},
+		ErrorClasses: map[string]bool{},
+		Backoff:      config.RetryBackoff,
+		MaxBackoff:   config.RetryMaxBackoff,
+	}
+	for _, on := range config.RetryOn {
+		on = strings.TrimSpace(on)
+		if on == "" {
+			continue
+		}
+		if code, err := strconv.Atoi(on); err == nil {
+			if code < 100 || code > 599 {
+				return nil, fmt.Errorf("bad retry status code %d", code)
+			}
+			policy.Statuses[code] = true
+		} else {
+			policy.ErrorClasses[on] = true
+		}
+	}
+	return policy, nil
+}
+
+// Retryable reports whether response should be retried.
+func (p *RetryPolicy) Retryable(response *Response) bool {
+	if !response.OK {
+		return p.ErrorClasses[ClassifyError(response.Error)]
+	}
+	return p.Statuses[response.StatusCode]
+}
+
+// Delay returns how long to wait before retry number retry, counting from 1.
+// A Retry-After from the server is honored up to MaxBackoff, otherwise the
+// backoff doubles on every retry up to MaxBackoff with half of it randomized.
+func (p *RetryPolicy) Delay(retry int, response *Response) time.Duration {
+	if response.RetryAfter > 0 {
+		if p.MaxBackoff > 0 && response.RetryAfter > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+		return response.RetryAfter
+	}
+
+	delay := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as a date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// RetryExecutor retries the requests of Executor according to Policy, like a
+// client that retries on its own. The returned response is the final attempt,
+// timed from the start of the first, along with how many attempts were made,
+// whether the first attempt failed, retryable or not, and whether the retries
+// ran out.
+type RetryExecutor struct {
+	Executor Executor
+	Policy   *RetryPolicy
+}
+
+func (e *RetryExecutor) Execute(ctx context.Context, i int) *Response {
+	response := e.Executor.Execute(ctx, i)
+	start := response.StartTime
+	response.Attempts = 1
+	response.FirstAttemptFailed = response.Failed()
+
+	firstAttemptFailed := response.FirstAttemptFailed
+	for retry := 1; e.Policy.Retryable(response); retry++ {
+		if retry > e.Policy.MaxRetries {
+			response.RetriesExhausted = true
+			break
+		}
+
+		timer := time.NewTimer(e.Policy.Delay(retry, response))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			response.RetriesExhausted = true
+			response.StartTime = start
+			return response
+		}
+
+		attempts := response.Attempts + 1
+		response = e.Executor.Execute(ctx, i)
+		response.Attempts = attempts
+		response.FirstAttemptFailed = firstAttemptFailed
+	}
+
+	response.StartTime = start
+	return response
+}
+
+// Close closes Executor if it is an io.Closer.
+func (e *RetryExecutor) Close() error {
+	if closer, ok := e.Executor.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
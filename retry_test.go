@@ -0,0 +1,86 @@
+package thrash
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// scriptedExecutor returns its responses in turn.
+type scriptedExecutor struct {
+	responses []Response
+	calls     int
+}
+
+func (e *scriptedExecutor) Execute(ctx context.Context, i int) *Response {
+	response := e.responses[e.calls]
+	e.calls++
+	response.StartTime = time.Now()
+	response.EndTime = response.StartTime
+	return &response
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second} {
+		delay := policy.Delay(retry, &Response{})
+		if delay < max/2 || delay > max {
+			t.Errorf("retry %d: got %v, want %v to %v", retry, delay, max/2, max)
+		}
+	}
+	if delay := policy.Delay(1, &Response{RetryAfter: 500 * time.Millisecond}); delay != 500*time.Millisecond {
+		t.Errorf("got %v for a Retry-After of 500ms", delay)
+	}
+	if delay := policy.Delay(1, &Response{RetryAfter: 24 * time.Hour}); delay != time.Second {
+		t.Errorf("got %v for a Retry-After of a day, want the 1s max backoff", delay)
+	}
+}
+
+func TestRetryExecutor(t *testing.T) {
+	policy, err := NewRetryPolicy(Configuration{Retries: 2, RetryOn: []string{"503", "connection_refused"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Classified as "other", so not retried.
+	other := errors.New("unexpected error")
+
+	tests := []struct {
+		name             string
+		responses        []Response
+		attempts         int
+		firstFailed      bool
+		finalFailed      bool
+		retriesExhausted bool
+	}{
+		{"ok", []Response{{OK: true, StatusCode: 200}}, 1, false, false, false},
+		{"retried", []Response{{OK: true, StatusCode: 503}, {OK: true, StatusCode: 200}}, 2, true, false, false},
+		{"gave up", []Response{{OK: true, StatusCode: 503}, {OK: true, StatusCode: 503}, {OK: true, StatusCode: 503}}, 3, true, true, true},
+		{"not retryable", []Response{{OK: true, StatusCode: 500}}, 1, true, true, false},
+		{"error", []Response{{OK: false, Error: other}}, 1, true, true, false},
+	}
+	for _, test := range tests {
+		executor := &RetryExecutor{Executor: &scriptedExecutor{responses: test.responses}, Policy: policy}
+		response := executor.Execute(context.Background(), 0)
+		if response.Attempts != test.attempts || response.FirstAttemptFailed != test.firstFailed ||
+			response.Failed() != test.finalFailed || response.RetriesExhausted != test.retriesExhausted {
+			t.Errorf("%s: got attempts %d, first failed %v, final failed %v, exhausted %v, want %d, %v, %v, %v", test.name,
+				response.Attempts, response.FirstAttemptFailed, response.Failed(), response.RetriesExhausted,
+				test.attempts, test.firstFailed, test.finalFailed, test.retriesExhausted)
+		}
+	}
+}
+
+func TestSummaryAttempts(t *testing.T) {
+	summary := &ResponseSummary{}
+	summary.AddResponse(&Response{OK: true, StatusCode: 200, Attempts: 1})
+	summary.AddResponse(&Response{OK: true, StatusCode: 200, Attempts: 2, FirstAttemptFailed: true})
+	summary.AddResponse(&Response{OK: true, StatusCode: 500, Attempts: 1, FirstAttemptFailed: true})
+	summary.AddResponse(&Response{OK: false, Error: errors.New("no such host"), Attempts: 1, FirstAttemptFailed: true})
+
+	if summary.NumFirstFailed != 3 || summary.NumFinalFailed != 2 || summary.NumRetried != 1 || summary.NumAttempts != 5 {
+		t.Errorf("got first failed %d, final failed %d, retried %d, attempts %d, want 3, 2, 1, 5",
+			summary.NumFirstFailed, summary.NumFinalFailed, summary.NumRetried, summary.NumAttempts)
+	}
+}
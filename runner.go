@@ -0,0 +1,277 @@
+package thrash
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of every response as it is collected.
+type Observer interface {
+	Observe(r *Response)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(r *Response)
+
+func (f ObserverFunc) Observe(r *Response) {
+	f(r)
+}
+
+// InFlightObserver is an Observer that also wants to know when each request
+// is sent, e.g. to track the number of requests in flight.
+type InFlightObserver interface {
+	Observer
+	RequestStarted()
+	RequestFinished()
+}
+
+// Reporter renders the summary of a finished run.
+type Reporter interface {
+	Report(config Configuration, summary *ResponseSummary) error
+}
+
+// Runner issues Config.NumRequests requests, at most Config.Concurrency at a
+// time, and summarizes the responses. With Config.Users set the requests are
+// instead shared out among that many VirtualUsers, each sending its next one
+// after its previous one and a think time. When Config.Workers is set the run
+// is distributed across those workers; they only stream back aggregates, so
+// Observers are not called in that mode.
+type Runner struct {
+	Config    Configuration
+	Executor  Executor
+	Users     []*VirtualUser
+	Observers []Observer
+
+	// Progress, if set, is called with the number of newly completed requests.
+	Progress func(n int)
+
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	stop      chan struct{}
+}
+
+func (r *Runner) stopped() chan struct{} {
+	r.stopOnce.Do(func() { r.stop = make(chan struct{}) })
+	return r.stop
+}
+
+// Stop ends the run early: no new requests are sent and Run returns once
+// those in flight have completed. It is safe to call more than once and from
+// an Observer.
+func (r *Runner) Stop() {
+	stop := r.stopped()
+	r.closeOnce.Do(func() { close(stop) })
+}
+
+// NewRunner returns a Runner for config using the executor for its URL, or
+// its virtual users. A zero Concurrency means DEFAULT_CONCURRENCY.
+func NewRunner(config Configuration) (*Runner, error) {
+	if config.Concurrency == 0 {
+		config.Concurrency = DEFAULT_CONCURRENCY
+	}
+	if config.Concurrency < 0 {
+		return nil, fmt.Errorf("bad concurrency %d", config.Concurrency)
+	}
+
+	if config.Users > 0 {
+		var jar http.CookieJar
+		if CookieMode(config) == COOKIES_SHARED {
+			var err error
+			if jar, err = NewCookieJar(config); err != nil {
+				return nil, err
+			}
+		}
+
+		users := make([]*VirtualUser, config.Users)
+		for id := range users {
+			user, err := NewVirtualUser(config, id)
+			if err != nil {
+				return nil, err
+			}
+			if jar != nil {
+				user.client.Jar = jar
+			}
+			users[id] = user
+		}
+		return &Runner{
+			Config: config,
+			Users:  users,
+		}, nil
+	}
+
+	executor, err := NewExecutor(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{
+		Config:   config,
+		Executor: executor,
+	}, nil
+}
+
+// Run performs the run and returns its summary. If ctx is canceled no new
+// requests are sent, and the summary of those already completed is returned
+// along with ctx's error. After Stop the summary is returned without an
+// error. An Executor that is an io.Closer is closed once the run is over.
+func (r *Runner) Run(ctx context.Context) (*ResponseSummary, error) {
+	summary := &ResponseSummary{}
+
+	if len(r.Config.Workers) > 0 {
+		err := runDistributed(ctx, r.Config, summary, r.Progress)
+		return summary, err
+	}
+
+	var responses <-chan *Response
+	if len(r.Users) > 0 {
+		responses = r.startUsers(ctx)
+	} else {
+		responses = r.start(ctx)
+	}
+
+	// Collect the responses
+	for response := range responses {
+		summary.AddResponse(response)
+		for _, observer := range r.Observers {
+			observer.Observe(response)
+		}
+		if r.Progress != nil {
+			r.Progress(1)
+		}
+	}
+
+	if closer, ok := r.Executor.(io.Closer); ok {
+		closer.Close()
+	}
+	for _, user := range r.Users {
+		user.Close()
+	}
+
+	return summary, ctx.Err()
+}
+
+// start queues up the requests in the background so responses are collected
+// while the run is in progress. The returned channel is closed once every
+// request has completed. With Config.Rate set requests are scheduled at that
+// rate, and a request that has to wait for a free slot is timed from its
+// scheduled start (see Response.ResponseTime).
+func (r *Runner) start(ctx context.Context) <-chan *Response {
+	stop := r.stopped()
+	sem := make(chan bool, r.Config.Concurrency)
+	ack := make(chan *Response, r.Config.Concurrency)
+
+	go func() {
+		var wg sync.WaitGroup
+		defer close(ack)
+		defer wg.Wait()
+
+		runStart := time.Now()
+		for i := 0; i < r.Config.NumRequests; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			var intendedStart time.Time
+			if r.Config.Rate > 0 {
+				intendedStart = runStart.Add(time.Duration(float64(i) / r.Config.Rate * float64(time.Second)))
+				select {
+				case <-time.After(time.Until(intendedStart)):
+				case <-ctx.Done():
+					return
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case sem <- true:
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				r.requestStarted()
+				response := r.Executor.Execute(ctx, i)
+				response.IntendedStartTime = response.StartTime
+				if !intendedStart.IsZero() {
+					response.IntendedStartTime = intendedStart
+				}
+				r.requestFinished()
+				ack <- response
+			}(i)
+		}
+	}()
+
+	return ack
+}
+
+// startUsers runs every virtual user in the background until Config.NumRequests
+// requests have been sent between them. Config.Rate does not apply: users
+// are paced by their think time.
+func (r *Runner) startUsers(ctx context.Context) <-chan *Response {
+	stop := r.stopped()
+	ack := make(chan *Response, len(r.Users))
+	next := int64(-1)
+
+	var wg sync.WaitGroup
+	for _, user := range r.Users {
+		wg.Add(1)
+		go func(user *VirtualUser) {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= r.Config.NumRequests {
+					return
+				}
+				select {
+				case <-stop:
+					return
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				r.requestStarted()
+				response := user.Execute(ctx, i)
+				response.IntendedStartTime = response.StartTime
+				r.requestFinished()
+				ack <- response
+
+				user.Think(ctx)
+			}
+		}(user)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ack)
+	}()
+
+	return ack
+}
+
+func (r *Runner) requestStarted() {
+	for _, observer := range r.Observers {
+		if o, ok := observer.(InFlightObserver); ok {
+			o.RequestStarted()
+		}
+	}
+}
+
+func (r *Runner) requestFinished() {
+	for _, observer := range r.Observers {
+		if o, ok := observer.(InFlightObserver); ok {
+			o.RequestFinished()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package thrash
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunnerClosesIdleConnections(t *testing.T) {
+	var mu sync.Mutex
+	open := map[net.Conn]bool{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		if state == http.StateClosed || state == http.StateHijacked {
+			delete(open, conn)
+		} else {
+			open[conn] = true
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	for _, config := range []Configuration{
+		{Url: server.URL, NumRequests: 20, Concurrency: 4},
+		{Url: server.URL, NumRequests: 20, Concurrency: 4, Stream: true},
+	} {
+		runner, err := NewRunner(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runner.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			n := len(open)
+			mu.Unlock()
+			if n == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("stream %v: %d connections still open after the run", config.Stream, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestNewRunnerDefaultsConcurrency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	runner, err := NewRunner(Configuration{Url: server.URL, NumRequests: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	summary, err := runner.Run(ctx)
+	if err != nil || summary.NumOK != 3 {
+		t.Errorf("got %d ok and %v, want 3 ok", summary.NumOK, err)
+	}
+
+	if _, err := NewRunner(Configuration{Url: server.URL, NumRequests: 3, Concurrency: -1}); err == nil {
+		t.Error("negative concurrency succeeded")
+	}
+	search := &CapacitySearch{Config: Configuration{Url: server.URL, NumRequests: 3}, Variable: SEARCH_CONCURRENCY, Start: 0.4, Max: 4}
+	if _, _, err := search.Run(ctx); err == nil {
+		t.Error("search starting below one connection succeeded")
+	}
+}
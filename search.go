@@ -0,0 +1,200 @@
+package thrash
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Variables a CapacitySearch can vary.
+const (
+	SEARCH_CONCURRENCY = "concurrency"
+	SEARCH_RATE        = "rate"
+)
+
+// Fraction of the offered rate a level searched by rate has to send at to
+// pass. A client that can't keep up, for instance because -c is too low for
+// the rate, fails the level rather than quietly offering less.
+const SEARCH_MIN_THROUGHPUT = 0.9
+
+// SLO is the service level a level has to meet to pass. The error rate
+// counts failed requests and 5xx responses.
+type SLO struct {
+	Percentile   float64
+	MaxLatency   time.Duration
+	MaxErrorRate float64 // percent
+}
+
+// SearchLevel is the outcome of the run at one level of the search.
+type SearchLevel struct {
+	Level      float64
+	Run        *SavedRun
+	Latency    time.Duration
+	ErrorRate  float64 // percent
+	Pass       bool
+	Violations []string
+}
+
+// CapacitySearch runs Config at increasing levels of Variable, either
+// concurrency or offered rate, to find the highest level that meets SLO.
+// With Step set the level grows by Step until the SLO is violated or Max is
+// passed. Otherwise it doubles from Start until the SLO is violated and then
+// bisects between the last passing and first failing levels. Each level runs
+// Config.NumRequests requests.
+type CapacitySearch struct {
+	Config   Configuration
+	Variable string
+	Start    float64
+	Max      float64
+	Step     float64
+	SLO      SLO
+
+	// OnLevel, if set, is called as each level finishes.
+	OnLevel func(level *SearchLevel)
+}
+
+// NewCapacitySearch returns the search configured by config.Search and the
+// search and SLO settings.
+func NewCapacitySearch(config Configuration) *CapacitySearch {
+	return &CapacitySearch{
+		Config:   config,
+		Variable: config.Search,
+		Start:    config.SearchStart,
+		Max:      config.SearchMax,
+		Step:     config.SearchStep,
+		SLO: SLO{
+			Percentile:   config.SLOPercentile,
+			MaxLatency:   config.SLOLatency,
+			MaxErrorRate: config.SLOErrorRate,
+		},
+	}
+}
+
+// Run performs the search and returns every level tested, in the order they
+// ran, and the highest passing level, which is nil if none passed.
+func (s *CapacitySearch) Run(ctx context.Context) ([]*SearchLevel, *SearchLevel, error) {
+	if s.Variable != SEARCH_CONCURRENCY && s.Variable != SEARCH_RATE {
+		return nil, nil, fmt.Errorf("unknown search variable %q (want %s or %s)", s.Variable, SEARCH_CONCURRENCY, SEARCH_RATE)
+	}
+	if s.round(s.Start) <= 0 || s.Max < s.Start {
+		return nil, nil, fmt.Errorf("bad search range %g to %g", s.Start, s.Max)
+	}
+
+	var levels []*SearchLevel
+	var best, failed *SearchLevel
+	run := func(level float64) (*SearchLevel, error) {
+		result, err := s.runLevel(ctx, level)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, result)
+		if s.OnLevel != nil {
+			s.OnLevel(result)
+		}
+		if result.Pass {
+			best = result
+		} else {
+			failed = result
+		}
+		return result, nil
+	}
+
+	// Grow until the SLO is violated.
+	for level := s.Start; level <= s.Max; level = s.next(level) {
+		result, err := run(level)
+		if err != nil {
+			return levels, best, err
+		}
+		if !result.Pass {
+			break
+		}
+	}
+
+	// Narrow the gap between the last pass and the first failure.
+	if s.Step == 0 && best != nil && failed != nil {
+		low, high := best.Level, failed.Level
+		for high-low > s.resolution(low) {
+			result, err := run(s.round((low + high) / 2))
+			if err != nil {
+				return levels, best, err
+			}
+			if result.Pass {
+				low = result.Level
+			} else {
+				high = result.Level
+			}
+		}
+	}
+
+	return levels, best, nil
+}
+
+func (s *CapacitySearch) next(level float64) float64 {
+	if s.Step > 0 {
+		return s.round(level + s.Step)
+	}
+	return s.round(level * 2)
+}
+
+// resolution is how close the bisection gets before it stops: a single
+// connection, or 5% of the rate.
+func (s *CapacitySearch) resolution(level float64) float64 {
+	if s.Variable == SEARCH_CONCURRENCY {
+		return 1
+	}
+	return math.Max(1, level/20)
+}
+
+func (s *CapacitySearch) round(level float64) float64 {
+	if s.Variable == SEARCH_CONCURRENCY {
+		return math.Round(level)
+	}
+	return level
+}
+
+func (s *CapacitySearch) runLevel(ctx context.Context, level float64) (*SearchLevel, error) {
+	config := s.Config
+	if s.Variable == SEARCH_CONCURRENCY {
+		config.Concurrency = int(level)
+	} else {
+		config.Rate = level
+	}
+
+	runner, err := NewRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := runner.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchLevel{
+		Level:   level,
+		Run:     NewSavedRun(config, summary),
+		Latency: summary.Percentiles([]float64{s.SLO.Percentile})[0],
+	}
+
+	failures := len(summary.Errors)
+	for code, count := range summary.StatusCounts {
+		if code >= 500 {
+			failures += count
+		}
+	}
+	if summary.NumResponses > 0 {
+		result.ErrorRate = float64(failures) / float64(summary.NumResponses) * 100
+	}
+
+	if s.SLO.MaxLatency > 0 && result.Latency > s.SLO.MaxLatency {
+		result.Violations = append(result.Violations, fmt.Sprintf("p%g %v > %v", s.SLO.Percentile, result.Latency, s.SLO.MaxLatency))
+	}
+	if result.ErrorRate > s.SLO.MaxErrorRate {
+		result.Violations = append(result.Violations, fmt.Sprintf("errors %.2f%% > %g%%", result.ErrorRate, s.SLO.MaxErrorRate))
+	}
+	if sent := summary.SendRate(); config.Rate > 0 && summary.NumResponses > 1 && sent < config.Rate*SEARCH_MIN_THROUGHPUT {
+		result.Violations = append(result.Violations, fmt.Sprintf("sent %.2f/s < %g%% of %g/s", sent, SEARCH_MIN_THROUGHPUT*100, config.Rate))
+	}
+	result.Pass = len(result.Violations) == 0
+	return result, nil
+}
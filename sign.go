@@ -0,0 +1,332 @@
+package thrash
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request signing schemes.
+const (
+	SIGN_SIGV4 = "sigv4"
+	SIGN_HMAC  = "hmac"
+)
+
+const DEFAULT_HMAC_HEADER = "Authorization"
+const DEFAULT_HMAC_ALGORITHM = "sha256"
+const DEFAULT_HMAC_ENCODING = "base64"
+const DEFAULT_HMAC_STRING = "{{method}}\\n{{path}}\\n{{date}}\\n{{body_sha256}}"
+const DEFAULT_HMAC_FORMAT = "HMAC {{key_id}}:{{signature}}"
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+const sigV4TimeFormat = "20060102T150405Z"
+
+// Signer signs each request just before it is sent, after any Authorizer.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// NewSigner returns the Signer for config.Sign, or nil if requests are not
+// signed. Unset AWS credentials and region are taken from the usual AWS_
+// environment variables.
+func NewSigner(config Configuration) (Signer, error) {
+	switch config.Sign {
+	case "":
+		return nil, nil
+	case SIGN_SIGV4:
+		signer := &SigV4Signer{
+			AccessKey:    firstNonEmpty(config.AWSAccessKey, os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretKey:    firstNonEmpty(config.AWSSecretKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			SessionToken: firstNonEmpty(config.AWSSessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+			Region:       firstNonEmpty(config.AWSRegion, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")),
+			Service:      config.AWSService,
+		}
+		if signer.AccessKey == "" || signer.SecretKey == "" {
+			return nil, errors.New("sigv4 signing needs an access key and secret key")
+		}
+		if signer.Region == "" || signer.Service == "" {
+			return nil, errors.New("sigv4 signing needs a region and service")
+		}
+		return signer, nil
+	case SIGN_HMAC:
+		return NewHMACSigner(config)
+	}
+	return nil, fmt.Errorf("unknown signing scheme %q", config.Sign)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// requestBody returns req's body without consuming it.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return body, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, data)
+	return mac.Sum(nil)
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4. For S3 the
+// payload hash is also sent in X-Amz-Content-Sha256, as S3 requires.
+type SigV4Signer struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+}
+
+func (s *SigV4Signer) Sign(req *http.Request) error {
+	return s.sign(req, time.Now())
+}
+
+func (s *SigV4Signer) sign(req *http.Request, now time.Time) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256Hex(body)
+
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	scope := strings.Join([]string{amzDate[:8], s.Region, s.Service, "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			headers[name] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	path = sigV4Escape(path, false)
+	if s.Service != "s3" {
+		// Every service but S3 expects the path to be encoded twice.
+		path = sigV4Escape(path, false)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		sigV4Query(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), amzDate[:8])
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigV4Query returns req's query string sorted and encoded as SigV4 requires.
+func sigV4Query(req *http.Request) string {
+	query := req.URL.Query()
+	pairs := []string{}
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes every byte of s but the RFC 3986 unreserved
+// characters, and slashes too unless it is a path.
+func sigV4Escape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// HMACSigner signs requests with an HMAC of StringToSign under Key, sent in
+// Header as Format. Both are templates: StringToSign may use {{method}},
+// {{path}}, {{host}}, {{date}}, {{timestamp}}, {{nonce}}, {{content_type}}
+// and {{body_sha256}}, and Format may use those as well as {{key_id}} and
+// {{signature}}. When {{date}} is used it is also sent as the Date header.
+type HMACSigner struct {
+	Key          []byte
+	KeyID        string
+	Header       string
+	Hash         func() hash.Hash
+	Base64       bool
+	StringToSign string
+	Format       string
+}
+
+// NewHMACSigner returns an HMACSigner for config. StringToSign may use go
+// escapes such as \n.
+func NewHMACSigner(config Configuration) (*HMACSigner, error) {
+	if config.HMACKey == "" {
+		return nil, errors.New("hmac signing needs a key")
+	}
+	signer := &HMACSigner{
+		Key:          []byte(config.HMACKey),
+		KeyID:        config.HMACKeyID,
+		Header:       firstNonEmpty(config.HMACHeader, DEFAULT_HMAC_HEADER),
+		StringToSign: firstNonEmpty(config.HMACStringToSign, DEFAULT_HMAC_STRING),
+		Format:       firstNonEmpty(config.HMACFormat, DEFAULT_HMAC_FORMAT),
+	}
+	if unquoted, err := strconv.Unquote(`"` + signer.StringToSign + `"`); err == nil {
+		signer.StringToSign = unquoted
+	}
+
+	switch firstNonEmpty(config.HMACAlgorithm, DEFAULT_HMAC_ALGORITHM) {
+	case "sha1":
+		signer.Hash = sha1.New
+	case "sha256":
+		signer.Hash = sha256.New
+	case "sha512":
+		signer.Hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unknown hmac algorithm %q", config.HMACAlgorithm)
+	}
+	switch firstNonEmpty(config.HMACEncoding, DEFAULT_HMAC_ENCODING) {
+	case "base64":
+		signer.Base64 = true
+	case "hex":
+	default:
+		return nil, fmt.Errorf("unknown hmac encoding %q", config.HMACEncoding)
+	}
+	return signer, nil
+}
+
+func (s *HMACSigner) Sign(req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	vars := map[string]string{
+		"method":       req.Method,
+		"path":         req.URL.RequestURI(),
+		"host":         host,
+		"date":         now.UTC().Format(http.TimeFormat),
+		"timestamp":    strconv.FormatInt(now.Unix(), 10),
+		"nonce":        hex.EncodeToString(nonce),
+		"content_type": req.Header.Get("Content-Type"),
+		"body_sha256":  sha256Hex(body),
+		"key_id":       s.KeyID,
+	}
+	expand := func(template string) string {
+		return variablePattern.ReplaceAllStringFunc(template, func(match string) string {
+			if value, ok := vars[match[2:len(match)-2]]; ok {
+				return value
+			}
+			return match
+		})
+	}
+
+	if strings.Contains(s.StringToSign, "{{date}}") || strings.Contains(s.Format, "{{date}}") {
+		req.Header.Set("Date", vars["date"])
+	}
+	mac := hmac.New(s.Hash, s.Key)
+	io.WriteString(mac, expand(s.StringToSign))
+	if s.Base64 {
+		vars["signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	} else {
+		vars["signature"] = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	req.Header.Set(s.Header, expand(s.Format))
+	return nil
+}
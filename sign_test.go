@@ -0,0 +1,158 @@
+package thrash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests and signatures from the AWS Signature Version 4 test suite.
+func TestSigV4SignerTestVectors(t *testing.T) {
+	signer := &SigV4Signer{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+		Service:   "service",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		url       string
+		signature string
+	}{
+		{"get-vanilla", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.sign(req, now); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=host;x-amz-date, Signature=" + test.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: Authorization = %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+// awsURIEncode encodes s as the SigV4 documentation describes, independently
+// of sigV4Escape.
+func awsURIEncode(s string, keep string) string {
+	const unreserved = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.~"
+	var encoded strings.Builder
+	for _, c := range []byte(s) {
+		if strings.IndexByte(unreserved+keep, c) >= 0 {
+			encoded.WriteByte(c)
+		} else {
+			encoded.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return encoded.String()
+}
+
+// sigV4Verifier is a handler that checks requests are signed for secret
+// the way AWS does, from the request as the server received it.
+func sigV4Verifier(t *testing.T, secret string, region string, service string) http.HandlerFunc {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		var credential, signedHeaders, signature string
+		if _, err := fmt.Sscanf(strings.Replace(auth, ",", "", -1), "AWS4-HMAC-SHA256 Credential=%s SignedHeaders=%s Signature=%s",
+			&credential, &signedHeaders, &signature); err != nil {
+			t.Errorf("bad Authorization %q: %v", auth, err)
+			http.Error(rw, "bad authorization", http.StatusForbidden)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		bodyHash := sha256.Sum256(body)
+
+		uri := awsURIEncode(r.URL.Path, "/")
+		if service != "s3" {
+			uri = awsURIEncode(uri, "/")
+		}
+		var query []string
+		for key, values := range r.URL.Query() {
+			for _, value := range values {
+				query = append(query, awsURIEncode(key, "")+"="+awsURIEncode(value, ""))
+			}
+		}
+		sort.Strings(query)
+		var headers strings.Builder
+		for _, name := range strings.Split(signedHeaders, ";") {
+			value := r.Header.Get(name)
+			if name == "host" {
+				value = r.Host
+			}
+			fmt.Fprintf(&headers, "%s:%s\n", name, value)
+		}
+
+		canonicalRequest := strings.Join([]string{
+			r.Method, uri, strings.Join(query, "&"), headers.String(), signedHeaders, hex.EncodeToString(bodyHash[:]),
+		}, "\n")
+		date := r.Header.Get("X-Amz-Date")
+		requestHash := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := strings.Join([]string{
+			"AWS4-HMAC-SHA256", date, strings.Join([]string{date[:8], region, service, "aws4_request"}, "/"), hex.EncodeToString(requestHash[:]),
+		}, "\n")
+		key := mac([]byte("AWS4"+secret), date[:8])
+		key = mac(key, region)
+		key = mac(key, service)
+		key = mac(key, "aws4_request")
+
+		if hex.EncodeToString(mac(key, stringToSign)) != signature {
+			t.Errorf("signature mismatch for canonical request:\n%s", canonicalRequest)
+			http.Error(rw, "signature mismatch", http.StatusForbidden)
+		}
+	}
+}
+
+func TestSigV4SignerVerifyingServer(t *testing.T) {
+	for _, service := range []string{"s3", "execute-api"} {
+		server := httptest.NewServer(sigV4Verifier(t, "secret", "eu-west-1", service))
+
+		signer := &SigV4Signer{AccessKey: "key", SecretKey: "secret", Region: "eu-west-1", Service: service}
+		paths := []string{
+			"/bucket/plain.txt",
+			"/bucket/it's a (key)!*+,;=@:$&.txt",
+			"/bucket/caf%C3%A9%20menu",
+		}
+		for _, path := range paths {
+			req, err := http.NewRequest("PUT", server.URL+strings.Replace(path, " ", "%20", -1)+"?b=2&a=x%2Fy",
+				strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "text/plain")
+			if err := signer.Sign(req); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s %s: server returned %s", service, path, resp.Status)
+			}
+		}
+		server.Close()
+	}
+}
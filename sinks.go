@@ -0,0 +1,369 @@
+package thrash
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_SINK_INTERVAL = "10s"
+
+// IntervalStats are the aggregated results of the responses collected during
+// a single sink interval.
+type IntervalStats struct {
+	Time             time.Time
+	Interval         time.Duration
+	NumResponses     int
+	NumOK            int
+	NumErrors        int
+	NumConnections   int
+	NumReused        int
+	NumRecycled      int
+	NumAttempts      int
+	NumRetried       int
+	NumFirstFailed   int
+	NumFinalFailed   int
+	NumGaveUp        int
+	BytesTransferred int64
+	SumResponseTimes time.Duration
+	MinResponseTime  time.Duration
+	MaxResponseTime  time.Duration
+	StatusCounts     map[int]int
+	ErrorClasses     map[string]int
+	ErrorMessages    map[string]int
+	Histogram        *LatencyHistogram
+
+	// Details carried to the controller of a distributed run so its summary
+	// matches a local one.
+	ProtoCounts      map[string]int
+	NumTLSHandshakes int
+	NumTLSResumed    int
+	SumTLSHandshakes time.Duration
+	TLSVersions      map[string]int
+	TLSCiphers       map[string]int
+	NumHandshakes    int
+	SumHandshakes    time.Duration
+	NumStreams       int
+	NumEvents        int
+	NumFirstEvents   int
+	SumFirstEvents   time.Duration
+	NumProxyTunnels  int
+	SumProxyTunnels  time.Duration
+	SumUpstreamTimes time.Duration
+	NumCookiesSent   int
+	NumCookiesSet    int
+	Addrs            map[string]*AddrStats
+	Sources          map[string]*AddrStats
+	Steps            map[string]*AddrStats
+}
+
+func (s *IntervalStats) avgResponseTime() time.Duration {
+	if s.NumOK == 0 {
+		return 0
+	}
+	return s.SumResponseTimes / time.Duration(s.NumOK)
+}
+
+// Sink receives aggregated interval stats and pushes them somewhere.
+type Sink interface {
+	Push(stats *IntervalStats) error
+}
+
+// IntervalAggregator accumulates responses and periodically pushes the
+// interval's stats to each sink.
+type IntervalAggregator struct {
+	mu      sync.Mutex
+	current *IntervalStats
+	sinks   []Sink
+	stop    chan bool
+	done    chan bool
+}
+
+func NewIntervalAggregator(sinks []Sink) *IntervalAggregator {
+	return &IntervalAggregator{
+		current: newIntervalStats(time.Now()),
+		sinks:   sinks,
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+}
+
+func newIntervalStats(start time.Time) *IntervalStats {
+	return &IntervalStats{
+		Time:          start,
+		StatusCounts:  map[int]int{},
+		ErrorClasses:  map[string]int{},
+		ErrorMessages: map[string]int{},
+		Histogram:     newLatencyHistogram(),
+	}
+}
+
+func (a *IntervalAggregator) Observe(r *Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := a.current
+	s.NumResponses++
+	if r.NewConnection {
+		s.NumConnections++
+	}
+	if r.ConnReused {
+		s.NumReused++
+	}
+	if r.ConnRecycled {
+		s.NumRecycled++
+	}
+	s.NumAttempts += r.Attempts
+	if r.Attempts > 1 {
+		s.NumRetried++
+	}
+	if r.FirstAttemptFailed {
+		s.NumFirstFailed++
+	}
+	if r.Attempts > 0 && r.Failed() {
+		s.NumFinalFailed++
+	}
+	if r.RetriesExhausted {
+		s.NumGaveUp++
+	}
+	s.observeDetails(r)
+	if r.OK == false {
+		s.NumErrors++
+		s.ErrorClasses[ClassifyError(r.Error)]++
+		s.ErrorMessages[r.Error.Error()]++
+		return
+	}
+
+	s.NumOK++
+	s.StatusCounts[r.StatusCode]++
+	if r.ContentLength != -1 {
+		s.BytesTransferred += r.ContentLength
+	}
+
+	responseTime := r.ResponseTime()
+	s.Histogram.record(responseTime)
+	s.SumResponseTimes += responseTime
+	s.SumUpstreamTimes += responseTime - r.ProxyDuration
+	if s.MinResponseTime == 0 || responseTime < s.MinResponseTime {
+		s.MinResponseTime = responseTime
+	}
+	if responseTime > s.MaxResponseTime {
+		s.MaxResponseTime = responseTime
+	}
+}
+
+func (s *IntervalStats) observeDetails(r *Response) {
+	if r.RemoteAddr != "" {
+		s.Addrs = addAddrStats(s.Addrs, r.RemoteAddr, r)
+	}
+	if r.LocalAddr != "" {
+		s.Sources = addAddrStats(s.Sources, r.LocalAddr, r)
+	}
+	if r.Step != "" {
+		s.Steps = addAddrStats(s.Steps, r.Step, r)
+	}
+	s.NumCookiesSent += r.CookiesSent
+	s.NumCookiesSet += r.CookiesSet
+	if r.ProxyDuration > 0 {
+		s.NumProxyTunnels++
+		s.SumProxyTunnels += r.ProxyDuration
+	}
+	if r.TLSVersion != "" {
+		s.NumTLSHandshakes++
+		s.SumTLSHandshakes += r.TLSDuration
+		if r.TLSResumed {
+			s.NumTLSResumed++
+		}
+		s.TLSVersions = addCount(s.TLSVersions, r.TLSVersion, 1)
+		s.TLSCiphers = addCount(s.TLSCiphers, r.TLSCipher, 1)
+	}
+	if r.Proto != "" {
+		s.ProtoCounts = addCount(s.ProtoCounts, r.Proto, 1)
+	}
+	if r.HandshakeDuration > 0 {
+		s.NumHandshakes++
+		s.SumHandshakes += r.HandshakeDuration
+	}
+	if r.Streamed {
+		s.NumStreams++
+		s.NumEvents += r.NumEvents
+		if r.NumEvents > 0 {
+			s.NumFirstEvents++
+			s.SumFirstEvents += r.TimeToFirstEvent
+		}
+	}
+}
+
+func addCount(counts map[string]int, key string, n int) map[string]int {
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	counts[key] += n
+	return counts
+}
+
+func (a *IntervalAggregator) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.flush()
+			case <-a.stop:
+				a.flush()
+				close(a.done)
+				return
+			}
+		}
+	}()
+}
+
+func (a *IntervalAggregator) flush() {
+	now := time.Now()
+
+	a.mu.Lock()
+	stats := a.current
+	a.current = newIntervalStats(now)
+	a.mu.Unlock()
+
+	stats.Interval = now.Sub(stats.Time)
+	stats.Time = now
+	for _, sink := range a.sinks {
+		if err := sink.Push(stats); err != nil {
+			log.Println("Error pushing metrics:", err)
+		}
+	}
+}
+
+// Close pushes the final partial interval and stops the aggregator.
+func (a *IntervalAggregator) Close() {
+	close(a.stop)
+	<-a.done
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InfluxSink writes interval stats as InfluxDB line protocol to an HTTP
+// write endpoint, e.g. http://localhost:8086/write?db=thrash.
+type InfluxSink struct {
+	url    string
+	tags   string
+	client *http.Client
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func NewInfluxSink(writeUrl string, tags map[string]string) (*InfluxSink, error) {
+	if _, err := url.ParseRequestURI(writeUrl); err != nil {
+		return nil, err
+	}
+
+	var tagStr strings.Builder
+	for _, key := range sortedTagKeys(tags) {
+		fmt.Fprintf(&tagStr, ",%s=%s", influxTagEscaper.Replace(key), influxTagEscaper.Replace(tags[key]))
+	}
+
+	return &InfluxSink{
+		url:    writeUrl,
+		tags:   tagStr.String(),
+		client: &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (s *InfluxSink) Push(stats *IntervalStats) error {
+	var body bytes.Buffer
+	timestamp := stats.Time.UnixNano()
+	fmt.Fprintf(&body, "thrash%s requests=%di,ok=%di,errors=%di,bytes=%di,avg_ms=%f,min_ms=%f,max_ms=%f,interval_ms=%f %d\n",
+		s.tags,
+		stats.NumResponses,
+		stats.NumOK,
+		stats.NumErrors,
+		stats.BytesTransferred,
+		durationMs(stats.avgResponseTime()),
+		durationMs(stats.MinResponseTime),
+		durationMs(stats.MaxResponseTime),
+		durationMs(stats.Interval),
+		timestamp,
+	)
+	for code, count := range stats.StatusCounts {
+		fmt.Fprintf(&body, "thrash_status%s,code=%d count=%di %d\n", s.tags, code, count, timestamp)
+	}
+
+	resp, err := s.client.Post(s.url, "text/plain; charset=utf-8", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("influx write returned %s", resp.Status)
+	}
+	return nil
+}
+
+// StatsDSink sends interval stats as StatsD metrics over UDP, using the
+// DogStatsD extension for tags.
+type StatsDSink struct {
+	conn net.Conn
+	tags string
+}
+
+func NewStatsDSink(addr string, tags map[string]string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagList []string
+	for _, key := range sortedTagKeys(tags) {
+		tagList = append(tagList, key+":"+tags[key])
+	}
+	tagStr := ""
+	if len(tagList) > 0 {
+		tagStr = "|#" + strings.Join(tagList, ",")
+	}
+
+	return &StatsDSink{conn: conn, tags: tagStr}, nil
+}
+
+func (s *StatsDSink) Push(stats *IntervalStats) error {
+	var lines []string
+	lines = append(lines,
+		fmt.Sprintf("thrash.requests:%d|c%s", stats.NumResponses, s.tags),
+		fmt.Sprintf("thrash.ok:%d|c%s", stats.NumOK, s.tags),
+		fmt.Sprintf("thrash.errors:%d|c%s", stats.NumErrors, s.tags),
+		fmt.Sprintf("thrash.bytes:%d|c%s", stats.BytesTransferred, s.tags),
+	)
+	if stats.NumOK > 0 {
+		lines = append(lines,
+			fmt.Sprintf("thrash.response_time.avg:%f|g%s", durationMs(stats.avgResponseTime()), s.tags),
+			fmt.Sprintf("thrash.response_time.min:%f|g%s", durationMs(stats.MinResponseTime), s.tags),
+			fmt.Sprintf("thrash.response_time.max:%f|g%s", durationMs(stats.MaxResponseTime), s.tags),
+		)
+	}
+	for code, count := range stats.StatusCounts {
+		lines = append(lines, fmt.Sprintf("thrash.status.%d:%d|c%s", code, count, s.tags))
+	}
+
+	// One metric per datagram keeps each packet well under typical MTUs.
+	for _, line := range lines {
+		if _, err := s.conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
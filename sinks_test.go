@@ -0,0 +1,150 @@
+package thrash
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu    sync.Mutex
+	stats []*IntervalStats
+}
+
+func (s *recordingSink) Push(stats *IntervalStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = append(s.stats, stats)
+	return nil
+}
+
+func testIntervalStats() *IntervalStats {
+	stats := newIntervalStats(time.Unix(1700000000, 0))
+	stats.Interval = 10 * time.Second
+	stats.NumResponses = 3
+	stats.NumOK = 2
+	stats.NumErrors = 1
+	stats.BytesTransferred = 200
+	stats.SumResponseTimes = 30 * time.Millisecond
+	stats.MinResponseTime = 10 * time.Millisecond
+	stats.MaxResponseTime = 20 * time.Millisecond
+	stats.StatusCounts[200] = 2
+	return stats
+}
+
+func TestIntervalAggregator(t *testing.T) {
+	sink := &recordingSink{}
+	aggregator := NewIntervalAggregator([]Sink{sink})
+	aggregator.Start(time.Hour)
+
+	start := time.Now()
+	aggregator.Observe(&Response{OK: true, StatusCode: 200, ContentLength: 100, StartTime: start, EndTime: start.Add(10 * time.Millisecond)})
+	aggregator.Observe(&Response{OK: true, StatusCode: 503, ContentLength: -1, StartTime: start, EndTime: start.Add(30 * time.Millisecond)})
+	aggregator.Observe(&Response{OK: false, Error: errors.New("connection refused"), StartTime: start, EndTime: start})
+	aggregator.Close()
+
+	if len(sink.stats) != 1 {
+		t.Fatalf("got %d pushes, want 1", len(sink.stats))
+	}
+	stats := sink.stats[0]
+	if stats.NumResponses != 3 || stats.NumOK != 2 || stats.NumErrors != 1 {
+		t.Errorf("got %d responses, %d ok, %d errors, want 3, 2, 1", stats.NumResponses, stats.NumOK, stats.NumErrors)
+	}
+	if stats.BytesTransferred != 100 {
+		t.Errorf("got %d bytes, want 100", stats.BytesTransferred)
+	}
+	if stats.avgResponseTime() != 20*time.Millisecond || stats.MinResponseTime != 10*time.Millisecond || stats.MaxResponseTime != 30*time.Millisecond {
+		t.Errorf("got avg %v, min %v, max %v, want 20ms, 10ms, 30ms", stats.avgResponseTime(), stats.MinResponseTime, stats.MaxResponseTime)
+	}
+	if stats.StatusCounts[200] != 1 || stats.StatusCounts[503] != 1 || stats.ErrorClasses["other"] != 1 {
+		t.Errorf("got status counts %v and error classes %v", stats.StatusCounts, stats.ErrorClasses)
+	}
+}
+
+func TestInfluxSink(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxSink(server.URL+"/write?db=thrash", map[string]string{"target": "example.com", "run id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Push(testIntervalStats()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "thrash,run\\ id=1,target=example.com requests=3i,ok=2i,errors=1i,bytes=200i,avg_ms=15.000000,min_ms=10.000000,max_ms=20.000000,interval_ms=10000.000000 1700000000000000000\n" +
+		"thrash_status,run\\ id=1,target=example.com,code=200 count=2i 1700000000000000000\n"
+	if body != want {
+		t.Errorf("got body\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestInfluxSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxSink(server.URL+"/write?db=missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Push(testIntervalStats()); err == nil {
+		t.Error("push to a failing endpoint succeeded")
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	sink, err := NewStatsDSink(listener.LocalAddr().String(), map[string]string{"target": "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Push(testIntervalStats()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	buf := make([]byte, MAX_DATAGRAM_SIZE)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < 8 {
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	sort.Strings(got)
+
+	want := []string{
+		"thrash.bytes:200|c|#target:example.com",
+		"thrash.errors:1|c|#target:example.com",
+		"thrash.ok:2|c|#target:example.com",
+		"thrash.requests:3|c|#target:example.com",
+		"thrash.response_time.avg:15.000000|g|#target:example.com",
+		"thrash.response_time.max:20.000000|g|#target:example.com",
+		"thrash.response_time.min:10.000000|g|#target:example.com",
+		"thrash.status.200:2|c|#target:example.com",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
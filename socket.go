@@ -0,0 +1,184 @@
+package thrash
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+)
+
+const DEFAULT_PAYLOAD = "ping\n"
+const DEFAULT_DELIMITER = "\n"
+
+// Largest datagram the UDP executor will read.
+const MAX_DATAGRAM_SIZE = 65535
+
+func (r *Response) fail(err error) *Response {
+	r.OK = false
+	r.Error = err
+	r.EndTime = time.Now()
+	return r
+}
+
+func socketTarget(config Configuration) (string, string) {
+	target, err := url.Parse(config.Url)
+	if err != nil {
+		return config.Url, config.Url
+	}
+	return target.Host, target.Scheme + "://" + target.Host
+}
+
+// dialSocket connects to addr with dialer if there is one and records the
+// connection's addresses on response.
+func dialSocket(ctx context.Context, dialer *Dialer, network string, addr string, timeout time.Duration, response *Response) (net.Conn, error) {
+	dial := (&net.Dialer{Timeout: timeout}).DialContext
+	if dialer != nil {
+		dial = dialer.DialContext
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	response.RemoteAddr = conn.RemoteAddr().String()
+	response.LocalAddr = localIP(conn)
+	return conn, nil
+}
+
+// TCPExecutor opens a new connection to Addr for each request, writes Payload
+// and reads the reply up to and including Delimiter. Connections go through
+// Dialer if it is set.
+type TCPExecutor struct {
+	Addr      string
+	Url       string
+	Payload   []byte
+	Delimiter byte
+	Timeout   time.Duration
+	Dialer    *Dialer
+}
+
+func NewTCPExecutor(config Configuration) (*TCPExecutor, error) {
+	if config.Proxy != "" {
+		return nil, errors.New("tcp targets can't be sent through a proxy")
+	}
+	dialer, err := NewDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	addr, targetUrl := socketTarget(config)
+	delimiter := DEFAULT_DELIMITER[0]
+	if config.Delimiter != "" {
+		delimiter = config.Delimiter[0]
+	}
+	return &TCPExecutor{
+		Addr:      addr,
+		Url:       targetUrl,
+		Payload:   []byte(config.Payload),
+		Delimiter: delimiter,
+		Timeout:   config.Timeout,
+		Dialer:    dialer,
+	}, nil
+}
+
+func (e *TCPExecutor) Execute(ctx context.Context, i int) *Response {
+	response := &Response{OK: true, Url: e.Url}
+
+	response.StartTime = time.Now()
+	conn, err := dialSocket(ctx, e.Dialer, "tcp", e.Addr, e.Timeout, response)
+	response.ConnectDuration = time.Since(response.StartTime)
+	if err != nil {
+		return response.fail(err)
+	}
+	defer conn.Close()
+
+	if e.Timeout > 0 {
+		conn.SetDeadline(response.StartTime.Add(e.Timeout))
+	}
+
+	if _, err := conn.Write(e.Payload); err != nil {
+		return response.fail(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	first, err := reader.ReadByte()
+	if err != nil {
+		return response.fail(err)
+	}
+	response.TimeToFirstByte = time.Since(response.StartTime)
+
+	length := int64(1)
+	if first != e.Delimiter {
+		rest, err := reader.ReadBytes(e.Delimiter)
+		length += int64(len(rest))
+		if err != nil {
+			response.ContentLength = length
+			return response.fail(err)
+		}
+	}
+
+	response.EndTime = time.Now()
+	response.Status = "OK"
+	response.ContentLength = length
+	return response
+}
+
+// UDPExecutor sends Payload to Addr as a single datagram for each request
+// and waits for one datagram in reply. Sockets are opened through Dialer if
+// it is set.
+type UDPExecutor struct {
+	Addr    string
+	Url     string
+	Payload []byte
+	Timeout time.Duration
+	Dialer  *Dialer
+}
+
+func NewUDPExecutor(config Configuration) (*UDPExecutor, error) {
+	if config.Proxy != "" {
+		return nil, errors.New("udp targets can't be sent through a proxy")
+	}
+	dialer, err := NewDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	addr, targetUrl := socketTarget(config)
+	return &UDPExecutor{
+		Addr:    addr,
+		Url:     targetUrl,
+		Payload: []byte(config.Payload),
+		Timeout: config.Timeout,
+		Dialer:  dialer,
+	}, nil
+}
+
+func (e *UDPExecutor) Execute(ctx context.Context, i int) *Response {
+	response := &Response{OK: true, Url: e.Url}
+
+	response.StartTime = time.Now()
+	conn, err := dialSocket(ctx, e.Dialer, "udp", e.Addr, e.Timeout, response)
+	if err != nil {
+		return response.fail(err)
+	}
+	defer conn.Close()
+
+	if e.Timeout > 0 {
+		conn.SetDeadline(response.StartTime.Add(e.Timeout))
+	}
+
+	if _, err := conn.Write(e.Payload); err != nil {
+		return response.fail(err)
+	}
+
+	buf := make([]byte, MAX_DATAGRAM_SIZE)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return response.fail(err)
+	}
+
+	response.EndTime = time.Now()
+	response.TimeToFirstByte = response.EndTime.Sub(response.StartTime)
+	response.Status = "OK"
+	response.ContentLength = int64(n)
+	return response
+}
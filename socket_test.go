@@ -0,0 +1,193 @@
+package thrash
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpEchoServer echoes every line it reads back on the same connection.
+func tcpEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadBytes('\n')
+					if err != nil {
+						return
+					}
+					conn.Write(line)
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+// udpEchoServer sends every datagram it receives back to its sender.
+func udpEchoServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, MAX_DATAGRAM_SIZE)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func runSocketTarget(t *testing.T, url string) *ResponseSummary {
+	runner, err := NewRunner(Configuration{
+		Url:         url,
+		NumRequests: 20,
+		Concurrency: 4,
+		Timeout:     5 * time.Second,
+		Payload:     "PING\n",
+		Delimiter:   "\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestTCPExecutor(t *testing.T) {
+	listener := tcpEchoServer(t)
+	defer listener.Close()
+
+	summary := runSocketTarget(t, "tcp://"+listener.Addr().String())
+	if summary.NumResponses != 20 || summary.NumOK != 20 {
+		t.Fatalf("got %d ok of %d, want 20 of 20: %v", summary.NumOK, summary.NumResponses, summary.Errors)
+	}
+	if summary.BytesTransferred != 20*5 {
+		t.Errorf("got %d bytes, want %d", summary.BytesTransferred, 20*5)
+	}
+}
+
+func TestTCPExecutorNoReply(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	executor, err := NewTCPExecutor(Configuration{Url: "tcp://" + listener.Addr().String(), Payload: "PING\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := executor.Execute(context.Background(), 0)
+	if response.OK || ClassifyError(response.Error) != "eof" && ClassifyError(response.Error) != "connection_reset" {
+		t.Errorf("got ok %v with error %v, want an eof or reset", response.OK, response.Error)
+	}
+}
+
+func TestUDPExecutor(t *testing.T) {
+	conn := udpEchoServer(t)
+	defer conn.Close()
+
+	summary := runSocketTarget(t, "udp://"+conn.LocalAddr().String())
+	if summary.NumResponses != 20 || summary.NumOK != 20 {
+		t.Fatalf("got %d ok of %d, want 20 of 20: %v", summary.NumOK, summary.NumResponses, summary.Errors)
+	}
+	if summary.BytesTransferred != 20*5 {
+		t.Errorf("got %d bytes, want %d", summary.BytesTransferred, 20*5)
+	}
+}
+
+func TestSocketExecutorsUseDialer(t *testing.T) {
+	listener := tcpEchoServer(t)
+	defer listener.Close()
+	conn := udpEchoServer(t)
+	defer conn.Close()
+
+	_, tcpPort, _ := net.SplitHostPort(listener.Addr().String())
+	_, udpPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+	for _, test := range []struct {
+		url       string
+		connectTo string
+	}{
+		{"tcp://echo.invalid:7", "echo.invalid:7:127.0.0.1:" + tcpPort},
+		{"udp://echo.invalid:7", "echo.invalid:7:127.0.0.1:" + udpPort},
+	} {
+		executor, err := NewExecutor(Configuration{
+			Url:         test.url,
+			Payload:     "PING\n",
+			Timeout:     5 * time.Second,
+			ConnectTo:   []string{test.connectTo},
+			SourceAddrs: []string{"127.0.0.1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := executor.Execute(context.Background(), 0)
+		if !response.OK || response.LocalAddr != "127.0.0.1" {
+			t.Errorf("%s: got ok %v from %q with error %v, want an echo from 127.0.0.1", test.url, response.OK, response.LocalAddr, response.Error)
+		}
+	}
+
+	if _, err := NewExecutor(Configuration{Url: "tcp://localhost:1", SourceAddrs: []string{"no-such-interface"}}); err == nil {
+		t.Error("tcp executor with a bad source succeeded")
+	}
+}
+
+func TestNewExecutor(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"tcp://localhost:6379", "*thrash.TCPExecutor"},
+		{"udp://localhost:53", "*thrash.UDPExecutor"},
+		{"ws://localhost/socket", "*thrash.WebSocketExecutor"},
+		{"http://localhost/", "*thrash.HTTPExecutor"},
+	}
+	for _, test := range tests {
+		executor, err := NewExecutor(Configuration{Url: test.url, Concurrency: 1})
+		if err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		if got := fmt.Sprintf("%T", executor); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.url, got, test.want)
+		}
+	}
+
+	executor, err := NewExecutor(Configuration{Url: "tcp://localhost:6379", Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := executor.(*RetryExecutor); !ok {
+		t.Errorf("with retries got %T, want *thrash.RetryExecutor", executor)
+	}
+}
@@ -0,0 +1,180 @@
+package thrash
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Size of the buffer used to read chunks from non-SSE streams.
+const STREAM_READ_BUFFER = 32 * 1024
+
+// StreamExecutor holds each response open as a long-lived stream and times
+// the events on it. For text/event-stream responses an event is a complete
+// Server-Sent Event; for anything else, such as a chunked response, it is
+// each chunk of the body as it is read. The stream is closed by the server,
+// after Duration, or after MaxEvents events, whichever comes first.
+type StreamExecutor struct {
+	Client    *http.Client
+	Generator RequestGenerator
+	Auth      Authorizer
+	Signer    Signer
+	Duration  time.Duration
+	MaxEvents int
+}
+
+// NewStreamExecutor returns a StreamExecutor for config. config.Timeout only
+// bounds the wait for the response headers, unless config.HeaderTimeout is
+// set, since the body is expected to stay open.
+func NewStreamExecutor(config Configuration) (*StreamExecutor, error) {
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.HeaderTimeout == 0 {
+		client.Transport.(*http.Transport).ResponseHeaderTimeout = config.Timeout
+	}
+	client.Timeout = 0
+
+	auth, err := NewAuthorizer(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := NewSigner(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamExecutor{
+		Client:    client,
+		Generator: NewRequestGenerator(config),
+		Auth:      auth,
+		Signer:    signer,
+		Duration:  config.StreamDuration,
+		MaxEvents: config.StreamEvents,
+	}, nil
+}
+
+func (e *StreamExecutor) Execute(ctx context.Context, i int) *Response {
+	response := &Response{OK: true, Streamed: true}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := e.Generator.NewRequest(ctx, i)
+	if err == nil && e.Auth != nil {
+		err = e.Auth.Authorize(ctx, req)
+	}
+	if err == nil && e.Signer != nil {
+		err = e.Signer.Sign(req)
+	}
+	if err != nil {
+		response.StartTime = time.Now()
+		return response.fail(err)
+	}
+
+	response.Url = req.URL.String()
+	req = traceRequest(req, response)
+	response.StartTime = time.Now()
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return response.fail(err)
+	}
+	defer resp.Body.Close()
+
+	response.Status = resp.Status
+	response.StatusCode = resp.StatusCode
+	response.Proto = resp.Proto
+	if resp.StatusCode == http.StatusUnauthorized && e.Auth != nil {
+		e.Auth.Unauthorized(resp)
+	}
+
+	// Closing the stream ourselves is a normal end, not an error.
+	var closed int32
+	stop := func() {
+		atomic.StoreInt32(&closed, 1)
+		cancel()
+	}
+	if e.Duration > 0 {
+		timer := time.AfterFunc(e.Duration, stop)
+		defer timer.Stop()
+	}
+
+	lastEvent := time.Time{}
+	event := func() {
+		now := time.Now()
+		if response.NumEvents == 0 {
+			response.TimeToFirstEvent = now.Sub(response.StartTime)
+		} else {
+			response.EventGaps = append(response.EventGaps, now.Sub(lastEvent))
+		}
+		lastEvent = now
+		response.NumEvents++
+		if e.MaxEvents > 0 && response.NumEvents >= e.MaxEvents {
+			stop()
+		}
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		err = readEvents(resp.Body, &response.ContentLength, event)
+	} else {
+		err = readChunks(resp.Body, &response.ContentLength, event)
+	}
+
+	response.EndTime = time.Now()
+	if err != nil && err != io.EOF && atomic.LoadInt32(&closed) == 0 {
+		response.OK = false
+		response.Error = err
+	}
+	return response
+}
+
+// Close closes the executor's idle connections.
+func (e *StreamExecutor) Close() error {
+	e.Client.CloseIdleConnections()
+	return nil
+}
+
+// readEvents calls event for every complete Server-Sent Event on body.
+func readEvents(body io.Reader, length *int64, event func()) error {
+	reader := bufio.NewReader(body)
+	pending := false
+	for {
+		line, err := reader.ReadString('\n')
+		*length += int64(len(line))
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if pending {
+				event()
+				pending = false
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, often used as a keep-alive.
+		default:
+			pending = true
+		}
+	}
+}
+
+// readChunks calls event for every read that returns data from body.
+func readChunks(body io.Reader, length *int64, event func()) error {
+	buf := make([]byte, STREAM_READ_BUFFER)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			*length += int64(n)
+			event()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -1,20 +1,21 @@
-package main
+// Package thrash is an HTTP micro benchmarker. Build a Configuration, run it
+// with a Runner and hand the resulting ResponseSummary to one or more
+// Reporters. The thrash command in cmd/thrash is a thin wrapper around it.
+package thrash
 
 import (
+	"crypto/tls"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"net/http"
-	_ "net/http/pprof"
-	"net/url"
-	"os"
+	"math"
+	"net"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/cheggaaa/pb"
 	"golang.org/x/text/message"
 )
 
@@ -22,27 +23,221 @@ const DEFAULT_NUM_REQUESTS = 100
 const DEFAULT_CONCURRENCY = 1
 const DEFAULT_TIMEOUT = "60s"
 
+// Configuration describes a run. The output related options (LogRequests,
+// MetricsAddr, ReportPath and so on) are acted on by the thrash command, not
+// by Runner.
 type Configuration struct {
-	Concurrency int
-	NumRequests int
-	Timeout     time.Duration
-	Histogram   bool
-	PrintErrors bool
-	Profile     bool
-	Url         string
-	Headers     map[string]string
-	Username    string
-	Password    string
+	Concurrency    int
+	NumRequests    int
+	Timeout        time.Duration
+	Histogram      bool
+	PrintErrors    bool
+	Profile        bool
+	Url            string
+	Headers        map[string]string
+	Username       string
+	Password       string
+	LogRequests    string
+	LogFormat      string
+	MetricsAddr    string
+	InfluxUrl      string
+	StatsDAddr     string
+	SinkInterval   time.Duration
+	Tags           map[string]string
+	ReportPath     string
+	JSONPath       string
+	Workers        []string
+	WorkerToken    string
+	Payload        string
+	Delimiter      string
+	WSMessages     []string
+	WSInterval     time.Duration
+	Stream         bool
+	StreamDuration time.Duration
+	StreamEvents   int
+	Protocol       string
+	MaxConns       int
+	StrictStreams  bool
+	TLSCert        string
+	TLSKey         string
+	TLSCA          string
+	TLSServerName  string
+	TLSMinVersion  string
+	TLSMaxVersion  string
+	TLSCiphers     []string
+	TLSInsecure    bool
+
+	DisableKeepAlives bool
+	ConnMaxRequests   int
+	ConnMaxAge        time.Duration
+
+	UnixSocket string
+	Resolve    []string
+	ConnectTo  []string
+	RoundRobin bool
+	Proxy      string
+
+	SourceAddrs []string
+
+	DialTimeout   time.Duration
+	TLSTimeout    time.Duration
+	HeaderTimeout time.Duration
+	BodyTimeout   time.Duration
+	IdleTimeout   time.Duration
+
+	Retries         int
+	RetryOn         []string
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+
+	Rate float64
+
+	Search        string
+	SearchStart   float64
+	SearchMax     float64
+	SearchStep    float64
+	SLOPercentile float64
+	SLOLatency    time.Duration
+	SLOErrorRate  float64
+
+	AbortWindow      time.Duration
+	AbortErrorRate   float64
+	AbortLatency     time.Duration
+	AbortPercentile  float64
+	AbortFor         time.Duration
+	AbortConsecutive int
+
+	Users     int
+	Scenario  *Scenario
+	ThinkTime string
+
+	Cookies    string
+	CookieFile string
+
+	BearerToken       string
+	OAuthTokenUrl     string
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthScopes       []string
+	Digest            bool
+	AuthExec          string
+
+	Sign             string
+	AWSRegion        string
+	AWSService       string
+	AWSAccessKey     string
+	AWSSecretKey     string
+	AWSSessionToken  string
+	HMACKey          string
+	HMACKeyID        string
+	HMACHeader       string
+	HMACAlgorithm    string
+	HMACEncoding     string
+	HMACStringToSign string
+	HMACFormat       string
 }
 
 type Response struct {
-	OK            bool
-	Error         error
-	StartTime     time.Time
-	EndTime       time.Time
-	Status        string
-	StatusCode    int
-	ContentLength int64
+	OK                 bool
+	Error              error
+	Url                string
+	IntendedStartTime  time.Time
+	StartTime          time.Time
+	EndTime            time.Time
+	Status             string
+	StatusCode         int
+	Proto              string
+	ContentLength      int64
+	DNSDuration        time.Duration
+	ConnectDuration    time.Duration
+	TLSDuration        time.Duration
+	ProxyDuration      time.Duration
+	TLSVersion         string
+	TLSCipher          string
+	TLSResumed         bool
+	TimeToFirstByte    time.Duration
+	HandshakeDuration  time.Duration
+	ConnReused         bool
+	NewConnection      bool
+	ConnRecycled       bool
+	RemoteAddr         string
+	LocalAddr          string
+	Step               string
+	CookiesSent        int
+	CookiesSet         int
+	RetryAfter         time.Duration
+	Attempts           int
+	FirstAttemptFailed bool
+	RetriesExhausted   bool
+	Streamed           bool
+	NumEvents          int
+	TimeToFirstEvent   time.Duration
+	EventGaps          []time.Duration
+}
+
+// Failed reports whether the request failed outright or got a 5xx response.
+func (r *Response) Failed() bool {
+	return !r.OK || r.StatusCode >= 500
+}
+
+// ResponseTime is the time from when the request was due to be sent to when
+// it completed. In a run at a fixed rate a request that waited for a free
+// slot is timed from its scheduled start, so a saturated client can't hide
+// the delay it adds.
+func (r *Response) ResponseTime() time.Duration {
+	if r.IntendedStartTime.IsZero() {
+		return r.EndTime.Sub(r.StartTime)
+	}
+	return r.EndTime.Sub(r.IntendedStartTime)
+}
+
+// ErrBodyTimeout is the error for a response whose body was not read within
+// Configuration.BodyTimeout.
+var ErrBodyTimeout = errors.New("timeout reading response body")
+
+// ClassifyError buckets a request error into a short, stable class name so
+// that errors can be counted and compared across runs. Timeouts are split by
+// the phase that timed out, with "timeout" for the overall request timeout.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrBodyTimeout) {
+		return "body_timeout"
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		var opErr *net.OpError
+		switch {
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			return "dial_timeout"
+		case strings.Contains(err.Error(), "TLS handshake timeout"):
+			return "tls_timeout"
+		case strings.Contains(err.Error(), "timeout awaiting response headers"):
+			return "header_timeout"
+		}
+		return "timeout"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return "tls"
+	}
+	switch {
+	case errors.Is(err, ErrDisconnected):
+		return "disconnect"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection_reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case strings.Contains(err.Error(), "tls:"):
+		return "tls"
+	}
+	return "other"
 }
 
 type ResponseSummary struct {
@@ -53,15 +248,169 @@ type ResponseSummary struct {
 	MaxResponseTime  time.Duration
 	MinResponseTime  time.Duration
 	ResponseTimes    []time.Duration
+	ResponseEndTimes []time.Time
 	StatusCounts     map[int]int
 	Errors           []error
+	ErrorTimes       []time.Time
+	ErrorClasses     map[string]int
+	StartTime        time.Time
+	EndTime          time.Time
+	LastStartTime    time.Time
+	NumHandshakes    int
+	SumHandshakes    time.Duration
+	NumStreams       int
+	NumEvents        int
+	NumFirstEvents   int
+	SumFirstEvents   time.Duration
+	EventGaps        []time.Duration
+	ProtoCounts      map[string]int
+	NumConnections   int
+	NumReused        int
+	NumRecycled      int
+	NumTLSHandshakes int
+	NumTLSResumed    int
+	SumTLSHandshakes time.Duration
+	TLSVersions      map[string]int
+	TLSCiphers       map[string]int
+	Addrs            map[string]*AddrStats
+	Sources          map[string]*AddrStats
+	Steps            map[string]*AddrStats
+	NumCookiesSent   int
+	NumCookiesSet    int
+	NumProxyTunnels  int
+	SumProxyTunnels  time.Duration
+	SumUpstreamTimes time.Duration
+	NumAttempts      int
+	NumRetried       int
+	NumFirstFailed   int
+	NumFinalFailed   int
+	NumGaveUp        int
+	AbortReason      string
+}
+
+// AddrStats are the results for the requests sent to, or from, one address.
+type AddrStats struct {
+	NumResponses     int
+	NumOK            int
+	SumResponseTimes time.Duration
+}
+
+func (a *AddrStats) add(r *Response) {
+	a.NumResponses++
+	if r.OK {
+		a.NumOK++
+		a.SumResponseTimes += r.ResponseTime()
+	}
+}
+
+func mergeAddrStats(stats map[string]*AddrStats, other map[string]*AddrStats) map[string]*AddrStats {
+	for addr, o := range other {
+		if stats == nil {
+			stats = map[string]*AddrStats{}
+		}
+		if stats[addr] == nil {
+			stats[addr] = &AddrStats{}
+		}
+		stats[addr].NumResponses += o.NumResponses
+		stats[addr].NumOK += o.NumOK
+		stats[addr].SumResponseTimes += o.SumResponseTimes
+	}
+	return stats
 }
 
-func (s *ResponseSummary) addResponse(r *Response) {
+func addAddrStats(stats map[string]*AddrStats, addr string, r *Response) map[string]*AddrStats {
+	if stats == nil {
+		stats = map[string]*AddrStats{}
+	}
+	if stats[addr] == nil {
+		stats[addr] = &AddrStats{}
+	}
+	stats[addr].add(r)
+	return stats
+}
+
+func (s *ResponseSummary) AddResponse(r *Response) {
 	s.NumResponses++
 
+	if r.NewConnection {
+		s.NumConnections++
+	}
+	if r.ConnReused {
+		s.NumReused++
+	}
+	if r.ConnRecycled {
+		s.NumRecycled++
+	}
+	if r.RemoteAddr != "" {
+		s.Addrs = addAddrStats(s.Addrs, r.RemoteAddr, r)
+	}
+	if r.LocalAddr != "" {
+		s.Sources = addAddrStats(s.Sources, r.LocalAddr, r)
+	}
+	if r.Step != "" {
+		s.Steps = addAddrStats(s.Steps, r.Step, r)
+	}
+	s.NumCookiesSent += r.CookiesSent
+	s.NumCookiesSet += r.CookiesSet
+	if r.Attempts > 0 {
+		s.addAttempts(r)
+	}
+	if r.ProxyDuration > 0 {
+		s.NumProxyTunnels++
+		s.SumProxyTunnels += r.ProxyDuration
+	}
+	if r.TLSVersion != "" {
+		s.NumTLSHandshakes++
+		s.SumTLSHandshakes += r.TLSDuration
+		if r.TLSResumed {
+			s.NumTLSResumed++
+		}
+		if s.TLSVersions == nil {
+			s.TLSVersions = map[string]int{}
+			s.TLSCiphers = map[string]int{}
+		}
+		s.TLSVersions[r.TLSVersion]++
+		s.TLSCiphers[r.TLSCipher]++
+	}
+	if r.Proto != "" {
+		if s.ProtoCounts == nil {
+			s.ProtoCounts = map[string]int{}
+		}
+		s.ProtoCounts[r.Proto]++
+	}
+
+	if r.HandshakeDuration > 0 {
+		s.NumHandshakes++
+		s.SumHandshakes += r.HandshakeDuration
+	}
+
+	if r.Streamed {
+		s.NumStreams++
+		s.NumEvents += r.NumEvents
+		if r.NumEvents > 0 {
+			s.NumFirstEvents++
+			s.SumFirstEvents += r.TimeToFirstEvent
+		}
+		s.EventGaps = append(s.EventGaps, r.EventGaps...)
+	}
+
+	if s.StartTime.IsZero() || r.StartTime.Before(s.StartTime) {
+		s.StartTime = r.StartTime
+	}
+	if r.EndTime.After(s.EndTime) {
+		s.EndTime = r.EndTime
+	}
+	if r.StartTime.After(s.LastStartTime) {
+		s.LastStartTime = r.StartTime
+	}
+
 	if r.OK == false {
 		s.Errors = append(s.Errors, r.Error)
+		s.ErrorTimes = append(s.ErrorTimes, r.EndTime)
+		if s.ErrorClasses == nil {
+			s.ErrorClasses = map[string]int{}
+		}
+		s.ErrorClasses[ClassifyError(r.Error)]++
 		return
 	}
 
@@ -76,10 +425,12 @@ func (s *ResponseSummary) addResponse(r *Response) {
 		s.BytesTransferred += r.ContentLength
 	}
 
-	responseTime := r.EndTime.Sub(r.StartTime)
+	responseTime := r.ResponseTime()
 	s.ResponseTimes = append(s.ResponseTimes, responseTime)
+	s.ResponseEndTimes = append(s.ResponseEndTimes, r.EndTime)
 
 	s.SumResponseTimes += responseTime
+	s.SumUpstreamTimes += responseTime - r.ProxyDuration
 
 	if s.MaxResponseTime == 0 {
 		s.MaxResponseTime = responseTime
@@ -98,7 +449,173 @@ func (s *ResponseSummary) addResponse(r *Response) {
 	}
 }
 
-func (s *ResponseSummary) print() {
+// MergeInterval folds interval stats received from a worker into the
+// summary. Individual response times are recovered from the interval's
+// histogram and stamped with the end of the interval.
+func (s *ResponseSummary) MergeInterval(interval *IntervalStats) {
+	intervalStart := interval.Time.Add(-interval.Interval)
+	if s.StartTime.IsZero() || intervalStart.Before(s.StartTime) {
+		s.StartTime = intervalStart
+	}
+	if interval.Time.After(s.EndTime) {
+		s.EndTime = interval.Time
+	}
+
+	s.NumResponses += interval.NumResponses
+	s.NumOK += interval.NumOK
+	s.NumConnections += interval.NumConnections
+	s.NumReused += interval.NumReused
+	s.NumRecycled += interval.NumRecycled
+	s.NumAttempts += interval.NumAttempts
+	s.NumRetried += interval.NumRetried
+	s.NumFirstFailed += interval.NumFirstFailed
+	s.NumFinalFailed += interval.NumFinalFailed
+	s.NumGaveUp += interval.NumGaveUp
+	s.BytesTransferred += interval.BytesTransferred
+	s.SumResponseTimes += interval.SumResponseTimes
+	s.mergeDetails(interval)
+
+	if interval.NumOK > 0 {
+		if s.MinResponseTime == 0 || interval.MinResponseTime < s.MinResponseTime {
+			s.MinResponseTime = interval.MinResponseTime
+		}
+		if interval.MaxResponseTime > s.MaxResponseTime {
+			s.MaxResponseTime = interval.MaxResponseTime
+		}
+	}
+
+	if s.StatusCounts == nil {
+		s.StatusCounts = map[int]int{}
+	}
+	for code, count := range interval.StatusCounts {
+		s.StatusCounts[code] += count
+	}
+
+	if s.ErrorClasses == nil {
+		s.ErrorClasses = map[string]int{}
+	}
+	for class, count := range interval.ErrorClasses {
+		s.ErrorClasses[class] += count
+	}
+	for message, count := range interval.ErrorMessages {
+		for i := 0; i < count; i++ {
+			s.Errors = append(s.Errors, errors.New(message))
+			s.ErrorTimes = append(s.ErrorTimes, interval.Time)
+		}
+	}
+
+	interval.Histogram.each(func(responseTime time.Duration, count int64) {
+		// Keep recovered values inside the exact bounds reported by the worker.
+		if responseTime < interval.MinResponseTime {
+			responseTime = interval.MinResponseTime
+		}
+		if responseTime > interval.MaxResponseTime {
+			responseTime = interval.MaxResponseTime
+		}
+		for i := int64(0); i < count; i++ {
+			s.ResponseTimes = append(s.ResponseTimes, responseTime)
+			s.ResponseEndTimes = append(s.ResponseEndTimes, interval.Time)
+		}
+	})
+}
+
+// mergeDetails folds in the protocol, TLS, connection, stream and address
+// counts of interval. Stream event gaps aren't carried by IntervalStats.
+func (s *ResponseSummary) mergeDetails(interval *IntervalStats) {
+	s.NumTLSHandshakes += interval.NumTLSHandshakes
+	s.NumTLSResumed += interval.NumTLSResumed
+	s.SumTLSHandshakes += interval.SumTLSHandshakes
+	s.NumHandshakes += interval.NumHandshakes
+	s.SumHandshakes += interval.SumHandshakes
+	s.NumStreams += interval.NumStreams
+	s.NumEvents += interval.NumEvents
+	s.NumFirstEvents += interval.NumFirstEvents
+	s.SumFirstEvents += interval.SumFirstEvents
+	s.NumProxyTunnels += interval.NumProxyTunnels
+	s.SumProxyTunnels += interval.SumProxyTunnels
+	s.SumUpstreamTimes += interval.SumUpstreamTimes
+	s.NumCookiesSent += interval.NumCookiesSent
+	s.NumCookiesSet += interval.NumCookiesSet
+	for proto, count := range interval.ProtoCounts {
+		s.ProtoCounts = addCount(s.ProtoCounts, proto, count)
+	}
+	for version, count := range interval.TLSVersions {
+		s.TLSVersions = addCount(s.TLSVersions, version, count)
+	}
+	for cipher, count := range interval.TLSCiphers {
+		s.TLSCiphers = addCount(s.TLSCiphers, cipher, count)
+	}
+	s.Addrs = mergeAddrStats(s.Addrs, interval.Addrs)
+	s.Sources = mergeAddrStats(s.Sources, interval.Sources)
+	s.Steps = mergeAddrStats(s.Steps, interval.Steps)
+}
+
+// Percentiles reported in the html report and saved runs.
+var summaryPercentiles = []float64{50, 75, 90, 95, 99, 99.9}
+
+// Percentiles returns the response time at each of the given percentiles
+// (0-100) of the successful responses.
+func (s *ResponseSummary) Percentiles(ps []float64) []time.Duration {
+	return durationPercentiles(s.ResponseTimes, ps)
+}
+
+// SendRate is the rate requests were actually sent at, from the spread of
+// their start times, so unlike the overall throughput it isn't held down by
+// the latency of the last responses. Summaries merged from workers have no
+// start times and fall back to the throughput.
+func (s *ResponseSummary) SendRate() float64 {
+	if s.NumResponses < 2 {
+		return 0
+	}
+	if spread := s.LastStartTime.Sub(s.StartTime); !s.LastStartTime.IsZero() && spread > 0 {
+		return float64(s.NumResponses-1) / spread.Seconds()
+	}
+	if duration := s.EndTime.Sub(s.StartTime); duration > 0 {
+		return float64(s.NumResponses) / duration.Seconds()
+	}
+	return 0
+}
+
+func (s *ResponseSummary) addAttempts(r *Response) {
+	s.NumAttempts += r.Attempts
+	if r.Attempts > 1 {
+		s.NumRetried++
+	}
+	if r.FirstAttemptFailed {
+		s.NumFirstFailed++
+	}
+	if r.Failed() {
+		s.NumFinalFailed++
+	}
+	if r.RetriesExhausted {
+		s.NumGaveUp++
+	}
+}
+
+func durationPercentiles(values []time.Duration, ps []float64) []time.Duration {
+	results := make([]time.Duration, len(ps))
+	if len(values) == 0 {
+		return results
+	}
+
+	sorted := make([]time.Duration, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for i, p := range ps {
+		index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if index < 0 {
+			index = 0
+		}
+		if index >= len(sorted) {
+			index = len(sorted) - 1
+		}
+		results[i] = sorted[index]
+	}
+	return results
+}
+
+func (s *ResponseSummary) Print() {
 	statusCountsString, _ := json.Marshal(s.StatusCounts)
 
 	pctOK := int((float64(s.NumOK) / float64(s.NumResponses)) * 100)
@@ -107,21 +624,110 @@ func (s *ResponseSummary) print() {
 		avgResponseTime = time.Duration(float64(s.SumResponseTimes) / float64(s.NumOK))
 	}
 	p := message.NewPrinter(message.MatchLanguage("en"))
+	if s.AbortReason != "" {
+		p.Printf("Aborted: %s\n", s.AbortReason)
+	}
 	p.Printf("Responses OK: %d%% (%d/%d), Errors: %d\n", pctOK, s.NumOK, s.NumResponses, len(s.Errors))
+	if len(s.ErrorClasses) > 0 {
+		errorClassesString, _ := json.Marshal(s.ErrorClasses)
+		p.Printf("Error Classes: %s\n", errorClassesString)
+	}
 	p.Printf("Status Codes: %s\n", statusCountsString)
 	p.Printf("Bytes Transferred: %d\n", s.BytesTransferred)
 	p.Printf("Avg Response Time: %v\n", avgResponseTime)
 	p.Printf("Min Response Time %v\n", s.MinResponseTime)
 	p.Printf("Max Response Time %v\n", s.MaxResponseTime)
+	if len(s.ProtoCounts) > 0 {
+		protoCountsString, _ := json.Marshal(s.ProtoCounts)
+		p.Printf("Protocols: %s\n", protoCountsString)
+	}
+	if s.NumConnections > 0 {
+		p.Printf("Connections Opened: %d, Reused: %d%% of requests, Recycled: %d\n",
+			s.NumConnections, s.NumReused*100/s.NumResponses, s.NumRecycled)
+	}
+	if len(s.Addrs) > 1 {
+		printAddrStats(p, "Addresses", s.Addrs)
+	}
+	if len(s.Sources) > 1 {
+		printAddrStats(p, "Source Addresses", s.Sources)
+	}
+	if len(s.Steps) > 1 {
+		printAddrStats(p, "Steps", s.Steps)
+	}
+	if s.NumCookiesSent > 0 || s.NumCookiesSet > 0 {
+		p.Printf("Cookies Sent: %d, Set: %d\n", s.NumCookiesSent, s.NumCookiesSet)
+	}
+	if s.NumAttempts > 0 {
+		p.Printf("First Attempt Succeeded: %d%%, Final Succeeded: %d%%\n",
+			(s.NumResponses-s.NumFirstFailed)*100/s.NumResponses, (s.NumResponses-s.NumFinalFailed)*100/s.NumResponses)
+		p.Printf("Retried: %d, Gave Up: %d, Attempts: %d, Amplification: %.2fx\n",
+			s.NumRetried, s.NumGaveUp, s.NumAttempts, float64(s.NumAttempts)/float64(s.NumResponses))
+	}
+	if s.NumProxyTunnels > 0 {
+		avgUpstreamTime := time.Duration(0)
+		if s.NumOK > 0 {
+			avgUpstreamTime = s.SumUpstreamTimes / time.Duration(s.NumOK)
+		}
+		p.Printf("Proxy Tunnels: %d, Avg Tunnel Time: %v, Avg Upstream Time: %v\n",
+			s.NumProxyTunnels, s.SumProxyTunnels/time.Duration(s.NumProxyTunnels), avgUpstreamTime)
+	}
+	if s.NumTLSHandshakes > 0 {
+		tlsVersionsString, _ := json.Marshal(s.TLSVersions)
+		tlsCiphersString, _ := json.Marshal(s.TLSCiphers)
+		p.Printf("TLS Handshakes: %d (%d%% resumed), Avg Handshake Time: %v\n",
+			s.NumTLSHandshakes, s.NumTLSResumed*100/s.NumTLSHandshakes, s.SumTLSHandshakes/time.Duration(s.NumTLSHandshakes))
+		p.Printf("TLS Versions: %s, Ciphers: %s\n", tlsVersionsString, tlsCiphersString)
+	}
+	if s.NumHandshakes > 0 {
+		duration := s.EndTime.Sub(s.StartTime)
+		p.Printf("Connections: %d, Avg Handshake Time: %v\n", s.NumHandshakes, s.SumHandshakes/time.Duration(s.NumHandshakes))
+		p.Printf("Messages/sec: %.2f, Disconnects: %d\n", float64(s.NumOK)/duration.Seconds(), s.ErrorClasses["disconnect"])
+	}
+	if s.NumStreams > 0 {
+		s.printStreams(p)
+	}
 }
 
-func (s *ResponseSummary) printErrors() {
+func printAddrStats(p *message.Printer, title string, addrStats map[string]*AddrStats) {
+	addrs := make([]string, 0, len(addrStats))
+	for addr := range addrStats {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	p.Printf("%s:\n", title)
+	for _, addr := range addrs {
+		stats := addrStats[addr]
+		avg := time.Duration(0)
+		if stats.NumOK > 0 {
+			avg = stats.SumResponseTimes / time.Duration(stats.NumOK)
+		}
+		p.Printf("  %s Responses: %d, OK: %d%%, Avg Response Time: %v\n",
+			addr, stats.NumResponses, stats.NumOK*100/stats.NumResponses, avg)
+	}
+}
+
+func (s *ResponseSummary) printStreams(p *message.Printer) {
+	p.Printf("Streams: %d, Events: %d (%.1f per stream)\n", s.NumStreams, s.NumEvents, float64(s.NumEvents)/float64(s.NumStreams))
+	if s.NumFirstEvents > 0 {
+		p.Printf("Avg Time To First Event: %v\n", s.SumFirstEvents/time.Duration(s.NumFirstEvents))
+	}
+	if len(s.EventGaps) > 0 {
+		gaps := durationPercentiles(s.EventGaps, []float64{50, 99, 100})
+		p.Printf("Inter-event Gap p50: %v, p99: %v, Max: %v\n", gaps[0], gaps[1], gaps[2])
+	}
+	if s.NumOK > 0 {
+		p.Printf("Avg Stream Duration: %v\n", time.Duration(float64(s.SumResponseTimes)/float64(s.NumOK)))
+	}
+}
+
+func (s *ResponseSummary) PrintErrors() {
 	for _, err := range s.Errors {
 		fmt.Println(err)
 	}
 }
 
-func (s *ResponseSummary) printHistogram() {
+func (s *ResponseSummary) PrintHistogram() {
 	scalingFactor := float64(100) / float64(len(s.ResponseTimes))
 	var buckets [5]int64
 	bucketLength := float64(s.MaxResponseTime-s.MinResponseTime) / 4
@@ -146,164 +752,15 @@ func (s *ResponseSummary) printHistogram() {
 	}
 }
 
-func fetchURL(ack chan<- *Response, config Configuration, client *http.Client) {
-	req, err := http.NewRequest("GET", config.Url, nil)
-	if err != nil {
-		fmt.Println("Error creating new request")
-	}
-
-	if config.Username != "" && config.Password != "" {
-		req.SetBasicAuth(config.Username, config.Password)
-	}
-
-	for key, value := range config.Headers {
-		req.Header.Add(key, value)
-	}
-
-	response := &Response{OK: true, StartTime: time.Now()}
-	resp, err := client.Do(req)
-	response.EndTime = time.Now()
-
-	if err != nil {
-		response.OK = false
-		response.Error = err
-		ack <- response
-		return
-	}
-
-	response.Status = resp.Status
-	response.StatusCode = resp.StatusCode
-	response.ContentLength = resp.ContentLength
-
-	defer resp.Body.Close()
-	_, err = io.Copy(ioutil.Discard, resp.Body)
-
-	if err != nil {
-		response.OK = false
-		response.Error = err
-		fmt.Println("Error reading response body", err)
-	}
-
-	ack <- response
-}
-
-func startProfiler() {
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
+// TextReporter prints the summary, and optionally the histogram, to stdout.
+type TextReporter struct {
+	Histogram bool
 }
 
-func configure() *Configuration {
-	config := Configuration{}
-	defaultTimeoutDuration, _ := time.ParseDuration(DEFAULT_TIMEOUT)
-	flag.IntVar(&config.Concurrency, "c", DEFAULT_CONCURRENCY, "how much concurrency")
-	flag.IntVar(&config.NumRequests, "n", DEFAULT_NUM_REQUESTS, "how many requests")
-	flag.DurationVar(&config.Timeout, "t", defaultTimeoutDuration, "request timeout in MS")
-	flag.BoolVar(&config.Histogram, "d", false, "print response time histogram")
-	flag.BoolVar(&config.PrintErrors, "e", false, "print errors")
-	//flag.BoolVar(&config.Profile, "p", false, "start the profile server on port 6060")
-	flag.StringVar(&config.Username, "u", "", "username for basic auth")
-	flag.StringVar(&config.Password, "p", "", "password for basic auth")
-	headerStr := flag.String("h", "", "request headers key:value")
-	flag.Parse()
-
-	flag.Usage = func() {
-		printUsage()
-	}
-
-	if len(os.Args) < 2 || os.Args[1] == "-help" {
-		printUsage()
-		os.Exit(1)
-	}
-
-	urlArg := os.Args[len(os.Args)-1]
-
-	_, err := url.ParseRequestURI(urlArg)
-	if err != nil {
-		fmt.Printf("Error: \"%s\" does not look like a valid url!\n", urlArg)
-		printUsage()
-		os.Exit(2)
-	} else {
-		config.Url = urlArg
-	}
-
-	if *headerStr != "" {
-		config.Headers = make(map[string]string)
-		headerSlice := strings.Fields(*headerStr)
-		for _, header := range headerSlice {
-			parts := strings.Split(header, ":")
-			if len(parts) != 2 {
-				return nil
-			}
-			key := parts[0]
-			value := parts[1]
-			config.Headers[key] = value
-		}
-	}
-
-	return &config
-}
-
-func printUsage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s [flags] url\n", os.Args[0])
-	flag.PrintDefaults()
-}
-
-func main() {
-	configPtr := configure()
-
-	if configPtr == nil {
-		fmt.Println("Error in configuration! Exiting!")
-		os.Exit(1)
-	}
-
-	config := *configPtr
-
-	fmt.Println("Thrashing", config.Url)
-
-	p := message.NewPrinter(message.MatchLanguage("en"))
-	p.Println("Concurrency", config.Concurrency, "Num Requests", config.NumRequests)
-
-	if config.Profile {
-		startProfiler()
-	}
-
-	sem := make(chan bool, config.Concurrency)
-	ack := make(chan *Response, config.NumRequests)
-
-	tr := &http.Transport{
-		MaxIdleConns:        config.Concurrency,
-		MaxIdleConnsPerHost: config.Concurrency,
-	}
-	client := http.Client{Transport: tr, Timeout: config.Timeout}
-
-	bar := pb.StartNew(config.NumRequests)
-
-	// Queue up the requests
-	for i := 0; i < config.NumRequests; i++ {
-		sem <- true
-		go func() {
-			defer func() { <-sem }()
-			fetchURL(ack, config, &client)
-			bar.Increment()
-		}()
-	}
-
-	summary := ResponseSummary{}
-
-	// Collect the responses
-	for i := 0; i < config.NumRequests; i++ {
-		response := <-ack
-		summary.addResponse(response)
-		if response.OK != true && config.PrintErrors {
-			fmt.Println(response.Error)
-		}
-	}
-
-	bar.Finish()
-
-	summary.print()
-	if config.Histogram {
-		summary.printHistogram()
+func (r *TextReporter) Report(config Configuration, summary *ResponseSummary) error {
+	summary.Print()
+	if r.Histogram {
+		summary.PrintHistogram()
 	}
+	return nil
 }
@@ -0,0 +1,67 @@
+package thrash
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error that timed out with the given message.
+type timeoutError string
+
+func (e timeoutError) Error() string   { return string(e) }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com/", Err: err}
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{ErrBodyTimeout, "body_timeout"},
+		{fmt.Errorf("reading body: %w", ErrBodyTimeout), "body_timeout"},
+		{urlError(&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}), "dial_timeout"},
+		{urlError(timeoutError("net/http: TLS handshake timeout")), "tls_timeout"},
+		{urlError(timeoutError("net/http: timeout awaiting response headers")), "header_timeout"},
+		{urlError(context.DeadlineExceeded), "timeout"},
+		{urlError(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), "dns"},
+		{urlError(&tls.CertificateVerificationError{Err: errors.New("unknown authority")}), "tls"},
+		{urlError(errors.New("remote error: tls: handshake failure")), "tls"},
+		{ErrDisconnected, "disconnect"},
+		{urlError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), "connection_refused"},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, "connection_reset"},
+		{urlError(io.EOF), "eof"},
+		{io.ErrUnexpectedEOF, "eof"},
+		{errors.New("something else"), "other"},
+	}
+	for _, test := range tests {
+		if got := ClassifyError(test.err); got != test.want {
+			t.Errorf("ClassifyError(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestSendRate(t *testing.T) {
+	summary := &ResponseSummary{}
+	start := time.Now()
+	for i := 0; i < 101; i++ {
+		sent := start.Add(time.Duration(i) * 5 * time.Millisecond)
+		summary.AddResponse(&Response{OK: true, StatusCode: 200, StartTime: sent, EndTime: sent.Add(100 * time.Millisecond)})
+	}
+	if rate := summary.SendRate(); math.Abs(rate-200) > 0.01 {
+		t.Errorf("got send rate %.2f/s, want 200/s regardless of latency", rate)
+	}
+}
@@ -0,0 +1,101 @@
+package thrash
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %q (want 1.0, 1.1, 1.2 or 1.3)", name)
+	}
+	return version, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// NewTLSConfig builds the client TLS configuration from config. Sessions are
+// cached so that resumption can be measured.
+func NewTLSConfig(config Configuration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.TLSInsecure,
+		ClientSessionCache: tls.NewLRUClientSessionCache(config.Concurrency),
+	}
+
+	var err error
+	if tlsConfig.MinVersion, err = parseTLSVersion(config.TLSMinVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion, err = parseTLSVersion(config.TLSMaxVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.CipherSuites, err = parseCipherSuites(config.TLSCiphers); err != nil {
+		return nil, err
+	}
+
+	if config.TLSCert != "" || config.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.TLSCA != "" {
+		pem, err := ioutil.ReadFile(config.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("loading ca bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// recordTLS notes the outcome of a completed handshake on response.
+func recordTLS(response *Response, state tls.ConnectionState) {
+	response.TLSVersion = tls.VersionName(state.Version)
+	response.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+	response.TLSResumed = state.DidResume
+}
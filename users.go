@@ -0,0 +1,301 @@
+package thrash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scenario is the sequence of requests each virtual user loops over.
+type Scenario struct {
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep is one request of a Scenario. Url may be relative to the
+// target url and defaults to it. {{name}} in Url, Headers and Body is replaced
+// with the user's variable of that name: user, iteration and request are
+// always set, and Extract sets more from each response body, using the first
+// group of a regular expression.
+type ScenarioStep struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Extract map[string]string `json:"extract"`
+}
+
+// LoadScenario reads a JSON scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	if err := json.Unmarshal(data, scenario); err != nil {
+		return nil, err
+	}
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s has no steps", path)
+	}
+	return scenario, nil
+}
+
+// Think time distributions.
+const (
+	THINK_CONSTANT    = "constant"
+	THINK_UNIFORM     = "uniform"
+	THINK_EXPONENTIAL = "exponential"
+	THINK_NORMAL      = "normal"
+)
+
+// ThinkTime is the pause a virtual user takes after each request, drawn from
+// Distribution: constant Mean, uniform between Min and Max, exponential with
+// Mean, or normal with Mean and StdDev, never below zero.
+type ThinkTime struct {
+	Distribution string
+	Min          time.Duration
+	Max          time.Duration
+	Mean         time.Duration
+	StdDev       time.Duration
+}
+
+// ParseThinkTime parses constant:1s, uniform:500ms-2s, exponential:1s or
+// normal:1s,200ms. An empty string means no think time.
+func ParseThinkTime(s string) (ThinkTime, error) {
+	think := ThinkTime{}
+	if s == "" {
+		return think, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return think, fmt.Errorf("bad think time %q (want distribution:params)", s)
+	}
+	think.Distribution = parts[0]
+
+	var err error
+	switch think.Distribution {
+	case THINK_CONSTANT, THINK_EXPONENTIAL:
+		think.Mean, err = time.ParseDuration(parts[1])
+	case THINK_UNIFORM:
+		bounds := strings.SplitN(parts[1], "-", 2)
+		if len(bounds) != 2 {
+			return think, fmt.Errorf("bad think time %q (want uniform:min-max)", s)
+		}
+		if think.Min, err = time.ParseDuration(bounds[0]); err == nil {
+			think.Max, err = time.ParseDuration(bounds[1])
+		}
+		if err == nil && think.Max < think.Min {
+			err = fmt.Errorf("max below min")
+		}
+	case THINK_NORMAL:
+		params := strings.SplitN(parts[1], ",", 2)
+		if len(params) != 2 {
+			return think, fmt.Errorf("bad think time %q (want normal:mean,stddev)", s)
+		}
+		if think.Mean, err = time.ParseDuration(params[0]); err == nil {
+			think.StdDev, err = time.ParseDuration(params[1])
+		}
+	default:
+		return think, fmt.Errorf("unknown think time distribution %q", think.Distribution)
+	}
+	if err != nil {
+		return think, fmt.Errorf("bad think time %q: %v", s, err)
+	}
+	return think, nil
+}
+
+// Sample draws a think time using rnd.
+func (t ThinkTime) Sample(rnd *rand.Rand) time.Duration {
+	var d float64
+	switch t.Distribution {
+	case THINK_CONSTANT:
+		d = float64(t.Mean)
+	case THINK_UNIFORM:
+		d = float64(t.Min) + rnd.Float64()*float64(t.Max-t.Min)
+	case THINK_EXPONENTIAL:
+		d = rnd.ExpFloat64() * float64(t.Mean)
+	case THINK_NORMAL:
+		d = rnd.NormFloat64()*float64(t.StdDev) + float64(t.Mean)
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+var variablePattern = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+// VirtualUser is one simulated client. It has its own connections, cookie
+// jar (unless cookies are shared) and variables and works through the
+// scenario in order, starting over at the end. A VirtualUser is not safe for
+// concurrent use.
+type VirtualUser struct {
+	ID        int
+	Vars      map[string]string
+	Scenario  *Scenario
+	ThinkTime ThinkTime
+
+	target    *url.URL
+	config    Configuration
+	executor  Executor
+	client    *http.Client
+	extracts  map[string]*regexp.Regexp
+	rand      *rand.Rand
+	step      int
+	iteration int
+	current   *ScenarioStep
+}
+
+// NewVirtualUser returns virtual user id for config. Without a scenario the
+// user repeats the request to config.Url, which has to be an HTTP url.
+func NewVirtualUser(config Configuration, id int) (*VirtualUser, error) {
+	target, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("virtual users need an http or https url, not %s://", target.Scheme)
+	}
+	think, err := ParseThinkTime(config.ThinkTime)
+	if err != nil {
+		return nil, err
+	}
+	scenario := config.Scenario
+	if scenario == nil {
+		scenario = &Scenario{Steps: []ScenarioStep{{}}}
+	}
+
+	u := &VirtualUser{
+		ID:        id,
+		Vars:      map[string]string{},
+		Scenario:  scenario,
+		ThinkTime: think,
+		target:    target,
+		config:    config,
+		extracts:  map[string]*regexp.Regexp{},
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+	}
+	for _, step := range scenario.Steps {
+		for name, pattern := range step.Extract {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("bad extract pattern for %s: %v", name, err)
+			}
+			u.extracts[pattern] = re
+		}
+	}
+
+	executor, err := NewHTTPExecutor(config)
+	if err != nil {
+		return nil, err
+	}
+	executor.Generator = RequestGeneratorFunc(u.newRequest)
+	executor.Inspect = u.inspect
+	u.client = executor.Client
+	u.executor = executor
+
+	policy, err := NewRetryPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		u.executor = &RetryExecutor{Executor: executor, Policy: policy}
+	}
+	return u, nil
+}
+
+func (u *VirtualUser) expand(s string) string {
+	return variablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if value, ok := u.Vars[match[2:len(match)-2]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+func (u *VirtualUser) newRequest(ctx context.Context, i int) (*http.Request, error) {
+	step := u.current
+	u.Vars["user"] = strconv.Itoa(u.ID)
+	u.Vars["iteration"] = strconv.Itoa(u.iteration)
+	u.Vars["request"] = strconv.Itoa(i)
+
+	target := u.target
+	if step.Url != "" {
+		ref, err := url.Parse(u.expand(step.Url))
+		if err != nil {
+			return nil, err
+		}
+		target = u.target.ResolveReference(ref)
+	}
+	method := step.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	body := strings.NewReader(u.expand(step.Body))
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.config.Username != "" && u.config.Password != "" && !u.config.Digest {
+		req.SetBasicAuth(u.config.Username, u.config.Password)
+	}
+	for key, value := range u.config.Headers {
+		req.Header.Add(key, value)
+	}
+	for key, value := range step.Headers {
+		req.Header.Set(key, u.expand(value))
+	}
+	return req, nil
+}
+
+func (u *VirtualUser) inspect(resp *http.Response, body []byte) {
+	for name, pattern := range u.current.Extract {
+		if match := u.extracts[pattern].FindSubmatch(body); len(match) > 1 {
+			u.Vars[name] = string(match[1])
+		}
+	}
+}
+
+// Execute sends the user's next scenario step as the i'th request of the run.
+func (u *VirtualUser) Execute(ctx context.Context, i int) *Response {
+	u.current = &u.Scenario.Steps[u.step]
+	response := u.executor.Execute(ctx, i)
+	response.Step = u.current.Name
+
+	u.step++
+	if u.step == len(u.Scenario.Steps) {
+		u.step = 0
+		u.iteration++
+	}
+	return response
+}
+
+// Think pauses for a think time, returning early if ctx is done.
+func (u *VirtualUser) Think(ctx context.Context) {
+	pause := u.ThinkTime.Sample(u.rand)
+	if pause <= 0 {
+		return
+	}
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Close closes the user's idle connections.
+func (u *VirtualUser) Close() error {
+	u.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,109 @@
+package thrash
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseThinkTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want ThinkTime
+	}{
+		{"", ThinkTime{}},
+		{"constant:1s", ThinkTime{Distribution: THINK_CONSTANT, Mean: time.Second}},
+		{"uniform:500ms-2s", ThinkTime{Distribution: THINK_UNIFORM, Min: 500 * time.Millisecond, Max: 2 * time.Second}},
+		{"exponential:1s", ThinkTime{Distribution: THINK_EXPONENTIAL, Mean: time.Second}},
+		{"normal:1s,200ms", ThinkTime{Distribution: THINK_NORMAL, Mean: time.Second, StdDev: 200 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		got, err := ParseThinkTime(test.s)
+		if err != nil {
+			t.Errorf("ParseThinkTime(%q): %v", test.s, err)
+		} else if got != test.want {
+			t.Errorf("ParseThinkTime(%q) = %+v, want %+v", test.s, got, test.want)
+		}
+	}
+
+	for _, s := range []string{"1s", "constant:soon", "uniform:2s", "uniform:2s-1s", "normal:1s", "poisson:1s"} {
+		if _, err := ParseThinkTime(s); err == nil {
+			t.Errorf("ParseThinkTime(%q) succeeded", s)
+		}
+	}
+}
+
+func TestThinkTimeSample(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	uniform := ThinkTime{Distribution: THINK_UNIFORM, Min: 500 * time.Millisecond, Max: 2 * time.Second}
+	normal := ThinkTime{Distribution: THINK_NORMAL, Mean: 10 * time.Millisecond, StdDev: time.Second}
+	for i := 0; i < 1000; i++ {
+		if d := uniform.Sample(rnd); d < uniform.Min || d > uniform.Max {
+			t.Fatalf("uniform sample %v outside %v to %v", d, uniform.Min, uniform.Max)
+		}
+		if d := normal.Sample(rnd); d < 0 {
+			t.Fatalf("negative normal sample %v", d)
+		}
+	}
+	if d := (ThinkTime{Distribution: THINK_CONSTANT, Mean: time.Second}).Sample(rnd); d != time.Second {
+		t.Errorf("constant sample %v, want 1s", d)
+	}
+}
+
+func TestVirtualUserScenario(t *testing.T) {
+	var mu sync.Mutex
+	carts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			fmt.Fprintf(rw, `{"token":"t%s"}`, r.URL.Query().Get("user"))
+		case "/cart":
+			mu.Lock()
+			carts[r.Header.Get("Authorization")]++
+			mu.Unlock()
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer server.Close()
+
+	runner, err := NewRunner(Configuration{
+		Url:         server.URL,
+		NumRequests: 12,
+		Users:       2,
+		Scenario: &Scenario{Steps: []ScenarioStep{
+			{Name: "login", Url: "/login?user={{user}}", Extract: map[string]string{"token": `"token":"([^"]+)"`}},
+			{Name: "cart", Url: "/cart", Headers: map[string]string{"Authorization": "Bearer {{token}}"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each user alternates between the steps, but the users need not share
+	// the requests evenly.
+	logins, cartViews := summary.Steps["login"].NumResponses, summary.Steps["cart"].NumResponses
+	if summary.NumOK != 12 || logins+cartViews != 12 || cartViews < 4 || logins-cartViews > 2 {
+		t.Errorf("got %d ok, %d logins and %d carts, want 12 ok alternating between them", summary.NumOK, logins, cartViews)
+	}
+	if carts["Bearer t0"]+carts["Bearer t1"] != cartViews {
+		t.Errorf("got carts %v, want every cart request sent with its user's token", carts)
+	}
+}
+
+func TestVirtualUsersNeedHTTP(t *testing.T) {
+	for _, url := range []string{"ws://localhost/socket", "tcp://localhost:6379", "udp://localhost:53"} {
+		if _, err := NewRunner(Configuration{Url: url, NumRequests: 1, Users: 1}); err == nil {
+			t.Errorf("NewRunner with users and %s succeeded", url)
+		}
+	}
+}
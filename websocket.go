@@ -0,0 +1,453 @@
+package thrash
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Placeholder in a scripted message that is replaced with a unique id, so the
+// reply carrying the same id can be matched to it.
+const WS_CORRELATION_ID = "{{id}}"
+
+// Largest message, or single frame, accepted from the server. A frame header
+// claiming more is treated as an error rather than allocated.
+const WS_MAX_MESSAGE_SIZE = 64 << 20
+
+// ErrDisconnected is returned when the server closes a WebSocket connection
+// while a message is waiting on its reply.
+var ErrDisconnected = errors.New("websocket: disconnected")
+
+// wsConn is a minimal RFC 6455 client connection.
+type wsConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	lastSend time.Time
+}
+
+// dialWebSocket connects to target, through a CONNECT tunnel if proxy is
+// set, and performs the opening handshake, recording connect, proxy, TLS and
+// total handshake durations on response.
+func dialWebSocket(ctx context.Context, target *url.URL, header http.Header, dialer *Dialer, proxy *url.URL, tlsConfig *tls.Config, timeout time.Duration, response *Response) (*wsConn, error) {
+	host := target.Host
+	if target.Port() == "" {
+		if target.Scheme == "wss" {
+			host = net.JoinHostPort(target.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(target.Hostname(), "80")
+		}
+	}
+
+	dial := (&net.Dialer{Timeout: timeout}).DialContext
+	if dialer != nil {
+		dial = dialer.DialContext
+	}
+
+	addr := host
+	if proxy != nil {
+		addr = proxyAddr(proxy)
+	}
+
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", addr)
+	response.ConnectDuration = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	response.RemoteAddr = conn.RemoteAddr().String()
+	response.LocalAddr = localIP(conn)
+
+	if timeout > 0 {
+		conn.SetDeadline(start.Add(timeout))
+	}
+
+	if proxy != nil {
+		tunnel, err := connectTunnel(ctx, conn, proxy, host, tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		response.ProxyDuration = time.Since(start)
+		conn = tunnel
+	}
+
+	if target.Scheme == "wss" {
+		tlsStart := time.Now()
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = target.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		response.TLSDuration = time.Since(tlsStart)
+		recordTLS(response, tlsConn.ConnectionState())
+		conn = tlsConn
+	}
+
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	path := target.RequestURI()
+	var request strings.Builder
+	fmt.Fprintf(&request, "GET %s HTTP/1.1\r\n", path)
+	fmt.Fprintf(&request, "Host: %s\r\n", target.Host)
+	request.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	fmt.Fprintf(&request, "Sec-WebSocket-Key: %s\r\n", key)
+	for name, values := range header {
+		for _, value := range values {
+			fmt.Fprintf(&request, "%s: %s\r\n", name, value)
+		}
+	}
+	request.WriteString("\r\n")
+
+	if _, err := io.WriteString(conn, request.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake returned %s", resp.Status)
+	}
+	accept := sha1.Sum([]byte(key + wsAcceptGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		conn.Close()
+		return nil, errors.New("websocket: bad Sec-WebSocket-Accept")
+	}
+
+	response.HandshakeDuration = time.Since(start)
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, reader: reader}, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header = append(header, 0x80|byte(length))
+	case length <= 0xffff:
+		header = append(header, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	// Client frames must be masked.
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	header = append(header, mask...)
+	masked := make([]byte, length)
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+
+	_, err := c.conn.Write(append(header, masked...))
+	return err
+}
+
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > WS_MAX_MESSAGE_SIZE {
+		return false, 0, nil, fmt.Errorf("websocket: %d byte frame exceeds %d byte limit", length, WS_MAX_MESSAGE_SIZE)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next data message, answering pings and assembling
+// fragmented messages along the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return nil, ErrDisconnected
+		}
+		if len(message)+len(payload) > WS_MAX_MESSAGE_SIZE {
+			return nil, fmt.Errorf("websocket: message exceeds %d byte limit", WS_MAX_MESSAGE_SIZE)
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) close() {
+	c.writeFrame(wsOpClose, []byte{0x03, 0xe8})
+	c.conn.Close()
+}
+
+// WebSocketExecutor treats each request as one message round trip. Every
+// concurrent virtual user keeps its own connection, opened on its first
+// message, and sends the scripted Messages in turn at most once per Interval.
+// Replies are matched by WS_CORRELATION_ID when the message contains it and
+// are otherwise assumed to be an echo. Each id is the request number behind a
+// prefix random to the executor, so it can't be mistaken for other numbers in
+// a reply. Connections are tunneled through Proxy with CONNECT if it is set.
+type WebSocketExecutor struct {
+	Url       *url.URL
+	Header    http.Header
+	TLSConfig *tls.Config
+	Dialer    *Dialer
+	Proxy     *url.URL
+	Messages  []string
+	Interval  time.Duration
+	Timeout   time.Duration
+
+	idPrefix string
+	conns    chan *wsConn
+
+	mu  sync.Mutex
+	all map[*wsConn]bool
+}
+
+func NewWebSocketExecutor(config Configuration) (*WebSocketExecutor, error) {
+	target, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := NewDialer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := parseProxy(config)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil && proxy.Scheme != "http" && proxy.Scheme != "https" {
+		return nil, fmt.Errorf("websocket targets can't use a %s proxy, only http or https", proxy.Scheme)
+	}
+
+	header := http.Header{}
+	for key, value := range config.Headers {
+		header.Add(key, value)
+	}
+	if config.Username != "" && config.Password != "" {
+		req := &http.Request{Header: header}
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	messages := config.WSMessages
+	if len(messages) == 0 {
+		messages = []string{config.Payload}
+	}
+
+	prefix := make([]byte, 6)
+	rand.Read(prefix)
+
+	return &WebSocketExecutor{
+		Url:       target,
+		Header:    header,
+		TLSConfig: tlsConfig,
+		Dialer:    dialer,
+		Proxy:     proxy,
+		Messages:  messages,
+		Interval:  config.WSInterval,
+		Timeout:   config.Timeout,
+		idPrefix:  "thrash-" + hex.EncodeToString(prefix) + "-",
+		conns:     make(chan *wsConn, config.Concurrency),
+		all:       map[*wsConn]bool{},
+	}, nil
+}
+
+func (e *WebSocketExecutor) Execute(ctx context.Context, i int) *Response {
+	response := &Response{OK: true, Url: e.Url.String()}
+
+	var conn *wsConn
+	select {
+	case conn = <-e.conns:
+		response.ConnReused = true
+		response.RemoteAddr = conn.conn.RemoteAddr().String()
+		response.LocalAddr = localIP(conn.conn)
+	default:
+		var err error
+		response.StartTime = time.Now()
+		conn, err = dialWebSocket(ctx, e.Url, e.Header, e.Dialer, e.Proxy, e.TLSConfig, e.Timeout, response)
+		if err != nil {
+			return response.fail(err)
+		}
+		e.mu.Lock()
+		e.all[conn] = true
+		e.mu.Unlock()
+	}
+
+	// Pace this virtual user's messages.
+	if wait := time.Until(conn.lastSend.Add(e.Interval)); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			e.conns <- conn
+			response.StartTime = time.Now()
+			return response.fail(ctx.Err())
+		}
+	}
+
+	message := e.Messages[i%len(e.Messages)]
+	id := ""
+	if strings.Contains(message, WS_CORRELATION_ID) {
+		id = e.idPrefix + strconv.Itoa(i)
+		message = strings.Replace(message, WS_CORRELATION_ID, id, -1)
+	}
+
+	if e.Timeout > 0 {
+		conn.conn.SetDeadline(time.Now().Add(e.Timeout))
+	}
+
+	response.StartTime = time.Now()
+	conn.lastSend = response.StartTime
+	if err := conn.writeFrame(wsOpText, []byte(message)); err != nil {
+		e.drop(conn)
+		return response.fail(err)
+	}
+
+	for {
+		reply, err := conn.readMessage()
+		if err != nil {
+			e.drop(conn)
+			return response.fail(err)
+		}
+		if response.TimeToFirstByte == 0 {
+			response.TimeToFirstByte = time.Since(response.StartTime)
+		}
+		if id == "" || containsID(string(reply), id) {
+			response.ContentLength = int64(len(reply))
+			break
+		}
+	}
+
+	response.EndTime = time.Now()
+	response.Status = "OK"
+	e.conns <- conn
+	return response
+}
+
+// containsID reports whether reply contains id as a whole, and not only as
+// the start of a longer id such as id 1 in id 12.
+func containsID(reply string, id string) bool {
+	for start := 0; ; {
+		n := strings.Index(reply[start:], id)
+		if n < 0 {
+			return false
+		}
+		end := start + n + len(id)
+		if end == len(reply) || reply[end] < '0' || reply[end] > '9' {
+			return true
+		}
+		start += n + 1
+	}
+}
+
+func (e *WebSocketExecutor) drop(conn *wsConn) {
+	conn.conn.Close()
+	e.mu.Lock()
+	delete(e.all, conn)
+	e.mu.Unlock()
+}
+
+// Close closes every open connection.
+func (e *WebSocketExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for conn := range e.all {
+		conn.close()
+	}
+	e.all = map[*wsConn]bool{}
+	return nil
+}
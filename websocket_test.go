@@ -0,0 +1,153 @@
+package thrash
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wsEchoServer accepts WebSocket connections and echoes every text message.
+func wsEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsAcceptGUID))
+		conn, buf, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		buf.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+		buf.Flush()
+
+		for {
+			var head [2]byte
+			if _, err := io.ReadFull(buf, head[:]); err != nil {
+				return
+			}
+			length := int(head[1] & 0x7f)
+			if length == 126 {
+				var ext [2]byte
+				io.ReadFull(buf, ext[:])
+				length = int(binary.BigEndian.Uint16(ext[:]))
+			}
+			var mask [4]byte
+			io.ReadFull(buf, mask[:])
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(buf, payload); err != nil {
+				return
+			}
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+			if head[0]&0x0f != wsOpText {
+				return
+			}
+			frame := []byte{0x80 | wsOpText, byte(length)}
+			if length >= 126 {
+				frame = []byte{0x80 | wsOpText, 126, byte(length >> 8), byte(length)}
+			}
+			buf.Write(append(frame, payload...))
+			buf.Flush()
+		}
+	}))
+}
+
+// connectProxy is an HTTP proxy that only tunnels CONNECT requests, recording
+// the target and credentials of each.
+type connectProxy struct {
+	mu      sync.Mutex
+	targets []string
+	auth    []string
+}
+
+func (p *connectProxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "CONNECT" {
+		http.Error(rw, "CONNECT only", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, r.Host)
+	p.auth = append(p.auth, r.Header.Get("Proxy-Authorization"))
+	p.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	conn, buf, err := rw.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go io.Copy(upstream, buf)
+	io.Copy(conn, upstream)
+}
+
+func TestWebSocketExecutor(t *testing.T) {
+	server := wsEchoServer()
+	defer server.Close()
+
+	runner, err := NewRunner(Configuration{
+		Url:         "ws" + strings.TrimPrefix(server.URL, "http") + "/echo",
+		NumRequests: 20,
+		Concurrency: 2,
+		Timeout:     5 * time.Second,
+		WSMessages:  []string{`{"id":"{{id}}"}`, "plain"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.NumOK != 20 || summary.NumHandshakes != 2 {
+		t.Errorf("got %d ok over %d handshakes, want 20 over 2: %v", summary.NumOK, summary.NumHandshakes, summary.Errors)
+	}
+}
+
+func TestWebSocketThroughProxy(t *testing.T) {
+	server := wsEchoServer()
+	defer server.Close()
+	proxy := &connectProxy{}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	executor, err := NewExecutor(Configuration{
+		Url:         "ws" + strings.TrimPrefix(server.URL, "http") + "/echo",
+		Proxy:       "http://user:secret@" + strings.TrimPrefix(proxyServer.URL, "http://"),
+		Concurrency: 1,
+		Timeout:     5 * time.Second,
+		Payload:     "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := executor.Execute(context.Background(), 0)
+	if !response.OK || response.ProxyDuration == 0 {
+		t.Fatalf("got ok %v with proxy time %v and error %v", response.OK, response.ProxyDuration, response.Error)
+	}
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	if len(proxy.targets) != 1 || proxy.targets[0] != strings.TrimPrefix(server.URL, "http://") || proxy.auth[0] != wantAuth {
+		t.Errorf("proxy saw %v with credentials %v", proxy.targets, proxy.auth)
+	}
+
+	if _, err := NewExecutor(Configuration{Url: "ws://localhost/", Proxy: "socks5://localhost:1080", Concurrency: 1}); err == nil {
+		t.Error("websocket target with a socks proxy succeeded")
+	}
+	if _, err := NewExecutor(Configuration{Url: "tcp://localhost:6379", Proxy: "http://localhost:3128", Concurrency: 1}); err == nil {
+		t.Error("tcp target with a proxy succeeded")
+	}
+}